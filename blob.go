@@ -3,11 +3,13 @@ package isodb
 import (
 	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
 	"hash"
 	"io"
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -38,11 +40,14 @@ type (
 const (
 	// Sha256 indicates Sha256 algorithm
 	Sha256 = HashAlg("sha256")
+
+	// Sha512 indicates Sha512 algorithm
+	Sha512 = HashAlg("sha512")
 )
 
 func (ha HashAlg) valid() bool {
 	switch ha {
-	case Sha256:
+	case Sha256, Sha512:
 		return true
 	}
 	return false
@@ -50,6 +55,10 @@ func (ha HashAlg) valid() bool {
 
 func (ha HashAlg) newHash() hash.Hash {
 	// TODO(andre): actually use a pool of hash
+	switch ha {
+	case Sha512:
+		return sha512.New()
+	}
 	return sha256.New()
 }
 
@@ -88,6 +97,22 @@ func NewBlobString(str string) Blob {
 	}
 }
 
+// ParseBlobRef parses the string representation of a BlobRef (see BlobRef.String)
+func ParseBlobRef(str string) (BlobRef, error) {
+	idx := strings.Index(str, ":")
+	if idx < 0 {
+		return BlobRef{}, errors.Errorf("isodb: invalid blob ref %q", str)
+	}
+	ref := BlobRef{Alg: HashAlg(str[:idx]), Value: str[idx+1:]}
+	if !ref.Alg.valid() {
+		return BlobRef{}, ErrInvalidHashAlgorithm
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(ref.Value); err != nil || len(ref.Value) == 0 {
+		return BlobRef{}, errors.Errorf("isodb: invalid blob ref %q", str)
+	}
+	return ref, nil
+}
+
 // ToBlob returns itself
 func (b Blob) ToBlob() Blob {
 	return b
@@ -107,6 +132,18 @@ func (b Blob) RefAlg(h HashAlg) (BlobRef, error) {
 	return h.ComputeBytes(b.Content)
 }
 
+// verifyBlob returns ErrCorruptBlob if the content of b does not hash to ref
+func verifyBlob(ref BlobRef, b Blob) error {
+	actual, err := b.RefAlg(ref.Alg)
+	if err != nil {
+		return err
+	}
+	if actual != ref {
+		return ErrCorruptBlob{Expected: ref, Actual: actual}
+	}
+	return nil
+}
+
 // ToBlob returns the encoded version of this BlobRef
 func (b BlobRef) ToBlob() Blob {
 	blob, err := defaultCodec.encode(b)
@@ -135,7 +172,7 @@ func (b BlobRef) String() string {
 }
 
 func (b BlobRef) less(o BlobRef) bool {
-	return b.Alg < o.Alg && b.Value < o.Value
+	return b.Alg < o.Alg || b.Alg == o.Alg && b.Value < o.Value
 }
 
 // Contains returns true if the parent is present
@@ -2,18 +2,31 @@ package isodb
 
 type (
 	inMemBlobMap struct {
+		// alg used to compute the refs of new items, zero means Sha256
+		alg   HashAlg
 		items map[BlobRef]Blob
 	}
+)
 
-	kvBlobMap struct {
-		kv    KV
-		cache blobMap
+func (bm *inMemBlobMap) put(b toBlober) BlobRef {
+	bm.ensureItems()
+	blob := b.ToBlob()
+	alg := bm.alg
+	if alg == "" {
+		alg = Sha256
 	}
-)
+	ref, err := blob.RefAlg(alg)
+	if err != nil {
+		panic(err)
+	}
+	bm.store(ref, blob)
+	return ref
+}
 
-func (bm *inMemBlobMap) put(b toBlober) {
+// store b under the given ref without recomputing it
+func (bm *inMemBlobMap) store(ref BlobRef, b Blob) {
 	bm.ensureItems()
-	bm.items[b.ToBlob().Ref()] = b.ToBlob()
+	bm.items[ref] = b
 }
 
 func (bm *inMemBlobMap) read(out interface{}, r BlobRef) bool {
@@ -59,57 +72,3 @@ func (bm *inMemBlobMap) raw(out []byte, r BlobRef) Blob {
 	}
 	return Blob{Content: append(out, b.Content...)}
 }
-
-func (km *kvBlobMap) put(b toBlober) {
-	km.cache.put(b)
-}
-
-func (km *kvBlobMap) has(b BlobRef) bool {
-	if km.cache.has(b) {
-		return true
-	}
-	has, err := km.kv.Has(b.String())
-	if err != nil {
-		panic(err)
-	}
-	return has
-}
-
-func (km *kvBlobMap) read(out interface{}, r BlobRef) bool {
-	if !km.cache.has(r) {
-		if !km.primeCache(r) {
-			return false
-		}
-	}
-	return km.cache.read(out, r)
-}
-
-func (km *kvBlobMap) raw(out []byte, r BlobRef) Blob {
-	if !km.cache.has(r) {
-		km.primeCache(r)
-	}
-	return km.cache.raw(out, r)
-}
-
-func (km *kvBlobMap) primeCache(r BlobRef) bool {
-	has, err := km.kv.Has(r.String())
-	if err != nil {
-		panic(err)
-	} else if !has {
-		return false
-	}
-
-	blob, err := km.kv.Get(r.String())
-	if err != nil {
-		panic(err)
-	}
-	if len(blob.Content) == 0 {
-		return false
-	}
-	km.cache.put(blob)
-	return true
-}
-
-func (km *kvBlobMap) keys() []BlobRef {
-	return km.cache.keys()
-}
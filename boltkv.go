@@ -1,9 +1,14 @@
 package isodb
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
+	"math/rand"
+	"time"
 
 	badger "github.com/dgraph-io/badger"
+	"github.com/segmentio/ksuid"
 )
 
 type (
@@ -11,6 +16,20 @@ type (
 	boltKV struct {
 		db *badger.DB
 	}
+
+	boltTx struct {
+		tx *badger.Txn
+	}
+)
+
+const (
+	// number of times a conflicting transaction is retried before giving up
+	maxConflictRetries = 10
+	minConflictBackoff = time.Millisecond
+	maxConflictBackoff = 100 * time.Millisecond
+
+	// prefix of the keys written by Watch to detect when the subscription is active
+	watchProbePrefix = "watch/"
 )
 
 // NewTempKV returns a kv-implementation using a temporary folder
@@ -41,7 +60,7 @@ func (bdb *boltKV) Put(k string, b Blob) error {
 func (bdb *boltKV) PutIf(k string, b Blob, fn CheckFn) (bool, error) {
 	var change bool
 	bk := []byte(k)
-	err := bdb.db.Update(func(tx *badger.Txn) error {
+	err := bdb.update(func(tx *badger.Txn) error {
 		item, err := tx.Get(bk)
 		if err == badger.ErrKeyNotFound {
 			item = nil
@@ -88,7 +107,9 @@ func (bdb *boltKV) Get(k string) (Blob, error) {
 	bk := []byte(k)
 	err := bdb.db.View(func(tx *badger.Txn) error {
 		item, err := tx.Get(bk)
-		if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return ErrKeyNotFound
+		} else if err != nil {
 			return err
 		}
 		if item == nil {
@@ -128,3 +149,143 @@ func (bdb *boltKV) Has(k string) (bool, error) {
 func (bdb *boltKV) PutNew(k string, b Blob) (bool, error) {
 	return bdb.PutIf(k, b, onlyIfMissing)
 }
+
+// Keys implements KV
+func (bdb *boltKV) Keys(prefix string) ([]string, error) {
+	var keys []string
+	bp := []byte(prefix)
+	err := bdb.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = bp
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(bp); it.ValidForPrefix(bp); it.Next() {
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// DeleteIf implements KV
+func (bdb *boltKV) DeleteIf(k string, fn CheckFn) (bool, error) {
+	var change bool
+	bk := []byte(k)
+	err := bdb.update(func(tx *badger.Txn) error {
+		item, err := tx.Get(bk)
+		if err == badger.ErrKeyNotFound {
+			change, err = fn(Blob{}, Blob{})
+		} else if err != nil {
+			return err
+		} else {
+			err = item.Value(func(v []byte) error {
+				change, err = fn(Blob{Content: v}, Blob{})
+				return err
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if !change {
+			return errNothingChanged
+		}
+		return tx.Delete(bk)
+	})
+	if err == errNothingChanged {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return change, nil
+}
+
+// Update implements KV
+func (bdb *boltKV) Update(fn func(tx KVTx) error) error {
+	return bdb.update(func(tx *badger.Txn) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+// Watch implements WatchKV using badger subscriptions.
+//
+// Badger registers the subscription asynchronously, so a probe key is written
+// until the subscription receives it and only then ready is called
+func (bdb *boltKV) Watch(ctx context.Context, prefix string, ready func(), fn func(k string, b Blob)) error {
+	probe := []byte(watchProbePrefix + ksuid.New().String())
+	active := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		seen := false
+		done <- bdb.db.Subscribe(ctx, func(list *badger.KVList) error {
+			for _, kv := range list.GetKv() {
+				if !bytes.Equal(kv.Key, probe) {
+					fn(string(kv.Key), Blob{Content: kv.Value})
+				} else if !seen {
+					seen = true
+					close(active)
+				}
+			}
+			return nil
+		}, []byte(prefix), probe)
+	}()
+	for backoff := minConflictBackoff; ; {
+		if err := bdb.Put(string(probe), Blob{}); err != nil {
+			return err
+		}
+		select {
+		case <-active:
+			ready()
+			if _, err := bdb.DeleteIf(string(probe), alwaysTrue); err != nil {
+				return err
+			}
+			return <-done
+		case err := <-done:
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConflictBackoff {
+			backoff = maxConflictBackoff
+		}
+	}
+}
+
+// update executes fn in a read-write transaction, transactions which conflict
+// with concurrent ones are retried up to maxConflictRetries times with an
+// exponential backoff
+func (bdb *boltKV) update(fn func(tx *badger.Txn) error) error {
+	backoff := minConflictBackoff
+	for i := 0; ; i++ {
+		err := bdb.db.Update(fn)
+		if err != badger.ErrConflict || i == maxConflictRetries {
+			return err
+		}
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+		if backoff *= 2; backoff > maxConflictBackoff {
+			backoff = maxConflictBackoff
+		}
+	}
+}
+
+// Get implements KVTx
+func (bt boltTx) Get(k string) (Blob, error) {
+	item, err := bt.tx.Get([]byte(k))
+	if err == badger.ErrKeyNotFound {
+		return Blob{}, ErrKeyNotFound
+	} else if err != nil {
+		return Blob{}, err
+	}
+	var b Blob
+	b.Content, err = item.ValueCopy(nil)
+	return b, err
+}
+
+// Put implements KVTx
+func (bt boltTx) Put(k string, b Blob) error {
+	return bt.tx.Set([]byte(k), b.Content)
+}
+
+// Delete implements KVTx
+func (bt boltTx) Delete(k string) error {
+	return bt.tx.Delete([]byte(k))
+}
@@ -0,0 +1,99 @@
+package isodb
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// CacheStats contains the usage statistics of the object cache
+	CacheStats struct {
+		Hits     uint64
+		Misses   uint64
+		Size     int
+		Capacity int
+	}
+
+	// objectCache is a LRU cache of decoded objects (File and Commit) keyed by their ref.
+	//
+	// Objects are immutable, so entries are never invalidated, only evicted.
+	objectCache struct {
+		mu       sync.Mutex
+		capacity int
+		items    map[BlobRef]*list.Element
+		lru      *list.List
+		hits     uint64
+		misses   uint64
+	}
+
+	cacheEntry struct {
+		ref   BlobRef
+		value interface{}
+	}
+)
+
+const (
+	// DefaultCacheSize is the number of decoded objects kept in memory by a Repo
+	DefaultCacheSize = 4096
+)
+
+func newObjectCache(capacity int) *objectCache {
+	return &objectCache{
+		capacity: capacity,
+		items:    make(map[BlobRef]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (oc *objectCache) get(ref BlobRef) (interface{}, bool) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	el, ok := oc.items[ref]
+	if !ok {
+		oc.misses++
+		return nil, false
+	}
+	oc.hits++
+	oc.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (oc *objectCache) put(ref BlobRef, value interface{}) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.capacity <= 0 {
+		return
+	}
+	if el, ok := oc.items[ref]; ok {
+		oc.lru.MoveToFront(el)
+		return
+	}
+	oc.items[ref] = oc.lru.PushFront(&cacheEntry{ref: ref, value: value})
+	oc.evict()
+}
+
+func (oc *objectCache) resize(capacity int) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.capacity = capacity
+	oc.evict()
+}
+
+func (oc *objectCache) evict() {
+	for oc.lru.Len() > oc.capacity && oc.lru.Len() > 0 {
+		el := oc.lru.Back()
+		oc.lru.Remove(el)
+		delete(oc.items, el.Value.(*cacheEntry).ref)
+	}
+}
+
+func (oc *objectCache) stats() CacheStats {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	return CacheStats{
+		Hits:     oc.hits,
+		Misses:   oc.misses,
+		Size:     oc.lru.Len(),
+		Capacity: oc.capacity,
+	}
+}
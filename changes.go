@@ -0,0 +1,134 @@
+package isodb
+
+import (
+	"context"
+)
+
+type (
+	// ChangeEvent is a document change emitted by Changes.
+	//
+	// The last event sent before the channel is closed may have Err set, in that case
+	// the other fields are empty.
+	ChangeEvent struct {
+		// Commit which introduced the change
+		Commit BlobRef
+		Key    DocumentKey
+		Old    BlobRef
+		New    BlobRef
+		Err    error
+	}
+
+	changesFeed struct {
+		repo *Repo
+		ptr  string
+		out  chan ChangeEvent
+	}
+)
+
+// Changes streams the documents changed between since and the commit pointed by ptr,
+// and then keeps streaming the changes made to ptr until ctx is done (see Watch).
+//
+// Changes are emitted one commit at a time, oldest first, following the first parent
+// of each commit. If since is not an ancestor of the pointer (ie, the pointer was reset)
+// the difference between since and the new value is emitted as a single commit.
+// An empty since streams every document from the first commit.
+//
+// The Commit of the last event received can be used as since to resume the feed, once
+// every event of that commit has been processed. The channel is closed when ctx is done
+// or an error happens.
+func (r *Repo) Changes(ctx context.Context, ptr string, since BlobRef) (<-chan ChangeEvent, error) {
+	if err := ValidatePointerName(ptr); err != nil {
+		return nil, err
+	}
+	feed := &changesFeed{repo: r, ptr: ptr, out: make(chan ChangeEvent)}
+	// subscribe before reading the pointer so no update is lost
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := r.subscribe(subCtx, ptr)
+	go func() {
+		defer close(feed.out)
+		defer cancel()
+		if err := feed.run(ctx, sub, since); err != nil && err != ctx.Err() {
+			feed.send(ctx, ChangeEvent{Err: err})
+		}
+	}()
+	return feed.out, nil
+}
+
+func (f *changesFeed) run(ctx context.Context, sub *pointerSub, current BlobRef) error {
+	for {
+		head, err := f.repo.GetPointer(f.ptr)
+		if err != nil && err != ErrPointerNotFound {
+			return err
+		}
+		// a removed pointer keeps the feed at the last known commit
+		if !head.IsZero() && head != current {
+			if err := f.emit(ctx, current, head); err != nil {
+				return err
+			}
+			current = head
+		}
+		// events only wake up the feed, the value is always read from the pointer
+		// since events might be dropped if the feed is slow
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ev := <-sub.ch:
+				waiting = ev.Name != f.ptr
+			}
+		}
+	}
+}
+
+// emit sends the changes made between from and to
+func (f *changesFeed) emit(ctx context.Context, from, to BlobRef) error {
+	var chain []BlobRef
+	for c := to; c != from; {
+		if c.IsZero() {
+			// from is not an ancestor of to
+			return f.emitDiff(ctx, from, to, to)
+		}
+		chain = append(chain, c)
+		commit, err := f.repo.GetCommit(c)
+		if err != nil {
+			return err
+		}
+		c = BlobRef{}
+		if len(commit.Parents) > 0 {
+			c = commit.Parents[0]
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		parent := from
+		if i+1 < len(chain) {
+			parent = chain[i+1]
+		}
+		if err := f.emitDiff(ctx, parent, chain[i], chain[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *changesFeed) emitDiff(ctx context.Context, from, to, commit BlobRef) error {
+	fromRoot, err := f.repo.commitRoot(from)
+	if err != nil {
+		return err
+	}
+	toRoot, err := f.repo.commitRoot(to)
+	if err != nil {
+		return err
+	}
+	return diffFiles(f.repo, fromRoot, toRoot, nil, func(c DocumentChange) error {
+		return f.send(ctx, ChangeEvent{Commit: commit, Key: c.Key, Old: c.Old, New: c.New})
+	})
+}
+
+func (f *changesFeed) send(ctx context.Context, ev ChangeEvent) error {
+	select {
+	case f.out <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
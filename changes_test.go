@@ -0,0 +1,88 @@
+package isodb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	repo := newRepo(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, second := NewRandomKey("docs"), NewRandomKey("docs")
+	c1, err := repo.Update(ctx, "heads/main", func(tx *Tx) error {
+		tx.Put(first, NewBlobString("1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := repo.Update(ctx, "heads/main", func(tx *Tx) error {
+		tx.Put(second, NewBlobString("2"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(ch <-chan ChangeEvent) ChangeEvent {
+		select {
+		case ev := <-ch:
+			if ev.Err != nil {
+				t.Fatal(ev.Err)
+			}
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for change")
+		}
+		return ChangeEvent{}
+	}
+
+	all, err := repo.Changes(ctx, "heads/main", BlobRef{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(all); ev.Commit != c1 || ev.Key != first || !ev.Old.IsZero() {
+		t.Fatalf("First change should come from the first commit: %v", ev)
+	}
+	if ev := next(all); ev.Commit != c2 || ev.Key != second {
+		t.Fatalf("Second change should come from the second commit: %v", ev)
+	}
+
+	feed, err := repo.Changes(ctx, "heads/main", c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(feed); ev.Commit != c2 || ev.Key != second {
+		t.Fatalf("Changes should start after since: %v", ev)
+	}
+
+	c3, err := repo.Update(ctx, "heads/main", func(tx *Tx) error {
+		tx.Delete(first)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(feed); ev.Commit != c3 || ev.Key != first || !ev.New.IsZero() {
+		t.Fatalf("Live changes should be streamed: %v", ev)
+	}
+
+	if err := repo.UpdatePointer("heads/main", c1, c3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		ev := next(feed)
+		restored := ev.Key == first && ev.Old.IsZero() && !ev.New.IsZero()
+		removed := ev.Key == second && !ev.Old.IsZero() && ev.New.IsZero()
+		if ev.Commit != c1 || !(restored || removed) {
+			t.Fatalf("Reset pointers should emit the difference: %v", ev)
+		}
+	}
+
+	cancel()
+	for range feed {
+	}
+}
@@ -1,5 +1,7 @@
 package isodb
 
+import "sort"
+
 type (
 	// Changeset is used to prepare a commit before actually commiting to it.
 	//
@@ -8,8 +10,14 @@ type (
 		// leafs for this changeset, aka, the actual information
 		leafs map[DocumentKey]Blob
 
+		// removed documents
+		removed map[DocumentKey]struct{}
+
 		// ref of the parent commit
 		parents BlobRefList
+
+		// ours is the parent used as the starting tree of merge commits
+		ours BlobRef
 	}
 )
 
@@ -27,12 +35,27 @@ func NewChangeset(parents ...BlobRef) *Changeset {
 // Put the document in the changeset to be later added to the commit
 func (c *Changeset) Put(k DocumentKey, b Blob) {
 	c.ensureLeafs()
+	delete(c.removed, k)
 	c.leafs[k] = b
 }
 
+// Delete the document from the commit, documents which do not exist are ignored by Apply
+func (c *Changeset) Delete(k DocumentKey) {
+	c.ensureLeafs()
+	delete(c.leafs, k)
+	c.removed[k] = struct{}{}
+}
+
+// Deleted returns true if the document was marked for removal
+func (c *Changeset) Deleted(k DocumentKey) bool {
+	_, ok := c.removed[k]
+	return ok
+}
+
 // Read the document in the changeset (only if the document is indexed for changing).
 //
-// The content is copied to buf and returned as a buf
+// The content is copied to buf and returned as a buf. Use Repo.ChangesetView to also read
+// documents from the parent commit.
 func (c *Changeset) Read(out []byte, k DocumentKey) (Blob, bool) {
 	c.ensureLeafs()
 	b, ok := c.leafs[k]
@@ -43,9 +66,56 @@ func (c *Changeset) Read(out []byte, k DocumentKey) (Blob, bool) {
 	return Blob{Content: out}, true
 }
 
+// Keys returns the documents changed by the changeset, including the removed ones, sorted by key
+func (c *Changeset) Keys() []DocumentKey {
+	keys := make([]DocumentKey, 0, len(c.leafs)+len(c.removed))
+	for k := range c.leafs {
+		keys = append(keys, k)
+	}
+	for k := range c.removed {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// changesSet returns true if any document of set is written or removed
+func (c *Changeset) changesSet(set string) bool {
+	for k := range c.leafs {
+		if k.Set == set {
+			return true
+		}
+	}
+	for k := range c.removed {
+		if k.Set == set {
+			return true
+		}
+	}
+	return false
+}
+
+// Parents returns the parents of the commit created by the changeset
+func (c *Changeset) Parents() BlobRefList {
+	return append(BlobRefList(nil), c.parents...)
+}
+
+// base returns the commit used as the starting point for this changeset, empty if there is none
+func (c *Changeset) base() BlobRef {
+	if !c.ours.IsZero() {
+		return c.ours
+	}
+	if len(c.parents) == 0 {
+		return BlobRef{}
+	}
+	return c.parents[0]
+}
+
 func (c *Changeset) ensureLeafs() {
 	if c.leafs != nil {
 		return
 	}
 	c.leafs = make(map[DocumentKey]Blob)
+	c.removed = make(map[DocumentKey]struct{})
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andrebq/isodb"
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	docOutput struct {
+		Key     string
+		Commit  isodb.BlobRef
+		Content []byte `json:",omitempty"`
+	}
+)
+
+func putCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	ref := fs.String("ref", "heads/main", "pointer to update")
+	set := fs.String("set", "", "set of the document")
+	key := fs.String("key", "", "ksuid of the document, a new one is generated if empty")
+	file := fs.String("file", "-", "file with the content of the document, - reads from stdin")
+	fs.Parse(args)
+	if *set == "" {
+		return fmt.Errorf("put: -set is required")
+	}
+	dk := isodb.NewRandomKey(*set)
+	if *key != "" {
+		k, err := ksuid.Parse(*key)
+		if err != nil {
+			return err
+		}
+		dk.K = k
+	}
+	content, err := readInput(e, *file)
+	if err != nil {
+		return err
+	}
+
+	head, err := e.repo.GetPointer(*ref)
+	if err != nil && err != isodb.ErrPointerNotFound {
+		return err
+	}
+	var cs *isodb.Changeset
+	if head.IsZero() {
+		cs = isodb.NewChangeset()
+	} else {
+		cs = isodb.NewChangeset(head)
+	}
+	cs.Put(dk, isodb.Blob{Content: content})
+	commit, err := e.repo.Apply(cs)
+	if err != nil {
+		return err
+	}
+	if err := e.repo.UpdatePointer(*ref, commit, head); err != nil {
+		return err
+	}
+	out := docOutput{Key: dk.String(), Commit: commit}
+	return e.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "%v %v\n", out.Key, out.Commit)
+	})
+}
+
+func getCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	ref := fs.String("ref", "heads/main", "pointer or commit to read from")
+	set := fs.String("set", "", "set of the document")
+	key := fs.String("key", "", "ksuid of the document")
+	fs.Parse(args)
+	k, err := ksuid.Parse(*key)
+	if err != nil {
+		return err
+	}
+	dk := isodb.DocumentKey{Set: *set, K: k}
+	commit, err := e.resolve(*ref)
+	if err != nil {
+		return err
+	}
+	content, err := e.repo.GetContentAtKey(commit, dk)
+	if err != nil {
+		return err
+	}
+	out := docOutput{Key: dk.String(), Commit: commit, Content: content.Content}
+	return e.print(out, func(w io.Writer) {
+		w.Write(content.Content)
+	})
+}
+
+func readInput(e *env, file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(e.stdin)
+	}
+	return ioutil.ReadFile(file)
+}
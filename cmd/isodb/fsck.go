@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+func fsckCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	fs.Parse(args)
+	report, err := e.repo.Fsck()
+	if err != nil {
+		return err
+	}
+	err = e.print(report, func(w io.Writer) {
+		for _, p := range report.Problems {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", p.Kind, p.Path, p.Ref, p.Detail)
+		}
+		fmt.Fprintf(w, "pointers: %v commits: %v files: %v blobs: %v problems: %v\n",
+			report.Pointers, report.Commits, report.Files, report.Blobs, len(report.Problems))
+	})
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return exitError(1)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/andrebq/isodb"
+)
+
+type (
+	logEntry struct {
+		Ref     isodb.BlobRef
+		Folder  isodb.BlobRef
+		Parents isodb.BlobRefList
+	}
+
+	diffEntry struct {
+		Key string
+		Old isodb.BlobRef `json:",omitempty"`
+		New isodb.BlobRef `json:",omitempty"`
+	}
+)
+
+func logCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	ref := fs.String("ref", "heads/main", "pointer or commit to start from")
+	max := fs.Int("n", 0, "maximum number of commits to show, 0 shows all")
+	fs.Parse(args)
+	current, err := e.resolve(*ref)
+	if err != nil {
+		return err
+	}
+	var entries []logEntry
+	for !current.IsZero() && (*max <= 0 || len(entries) < *max) {
+		c, err := e.repo.GetCommit(current)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, logEntry{Ref: current, Folder: c.Folder, Parents: c.Parents})
+		current = isodb.BlobRef{}
+		if len(c.Parents) > 0 {
+			current = c.Parents[0]
+		}
+	}
+	return e.print(entries, func(w io.Writer) {
+		for _, le := range entries {
+			fmt.Fprintf(w, "commit %v\n", le.Ref)
+			for _, p := range le.Parents {
+				fmt.Fprintf(w, "parent %v\n", p)
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+func diffCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: isodb diff <from> <to>\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitError(2)
+	}
+	from, err := e.resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	to, err := e.resolve(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	changes, err := e.repo.Diff(from, to)
+	if err != nil {
+		return err
+	}
+	entries := make([]diffEntry, 0, len(changes))
+	for _, c := range changes {
+		entries = append(entries, diffEntry{Key: c.Key.String(), Old: c.Old, New: c.New})
+	}
+	return e.print(entries, func(w io.Writer) {
+		for _, de := range entries {
+			op := "M"
+			if de.Old.IsZero() {
+				op = "A"
+			} else if de.New.IsZero() {
+				op = "D"
+			}
+			fmt.Fprintf(w, "%v\t%v\n", op, de.Key)
+		}
+	})
+}
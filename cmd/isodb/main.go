@@ -0,0 +1,137 @@
+// Command isodb inspects and manipulates isodb repositories stored on disk.
+//
+// Usage:
+//
+//	isodb [-dir folder] [-json] <command> [arguments]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/andrebq/isodb"
+)
+
+type (
+	command struct {
+		usage string
+		run   func(env *env, args []string) error
+	}
+
+	env struct {
+		dir    string
+		json   bool
+		stdout io.Writer
+		stderr io.Writer
+		stdin  io.Reader
+		repo   *isodb.Repo
+	}
+
+	// exitError is returned by commands which completed but must exit with a non-zero code
+	exitError int
+)
+
+var commands = map[string]command{
+	"init":       {usage: "create a new repository", run: initCmd},
+	"put":        {usage: "write a document and advance a pointer", run: putCmd},
+	"get":        {usage: "read a document", run: getCmd},
+	"log":        {usage: "show the history of a pointer or commit", run: logCmd},
+	"diff":       {usage: "list documents changed between two commits", run: diffCmd},
+	"ref":        {usage: "read (get), update (set), list or delete pointers", run: refCmd},
+	"cat-object": {usage: "write the raw content of an object", run: catObjectCmd},
+	"fsck":       {usage: "check the consistency of the repository", run: fsckCmd},
+	"serve":      {usage: "serve the repository over http", run: serveCmd},
+	"import":     {usage: "write documents from newline-delimited json", run: importCmd},
+	"export":     {usage: "write documents as newline-delimited json or tar", run: exportCmd},
+}
+
+func main() {
+	e := &env{stdout: os.Stdout, stderr: os.Stderr, stdin: os.Stdin}
+	flag.StringVar(&e.dir, "dir", ".", "folder containing the repository")
+	flag.BoolVar(&e.json, "json", false, "write output as json")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "isodb: unknown command %v\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	err := e.open(flag.Arg(0) == "init")
+	if err == nil {
+		err = cmd.run(e, flag.Args()[1:])
+		e.close()
+	}
+	if code, ok := err.(exitError); ok {
+		os.Exit(int(code))
+	} else if err != nil {
+		fmt.Fprintf(e.stderr, "isodb: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: isodb [flags] <command> [arguments]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	var names []string
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %-12v %v\n", n, commands[n].usage)
+	}
+}
+
+// open the repository in e.dir, only creating it if create is set
+func (e *env) open(create bool) error {
+	// badger creates the MANIFEST when the database is opened for the first time
+	if _, err := os.Stat(filepath.Join(e.dir, "MANIFEST")); !create && os.IsNotExist(err) {
+		return fmt.Errorf("%v is not a repository, use isodb init to create one", e.dir)
+	}
+	repo, err := isodb.NewPersistentRepo(e.dir)
+	if err != nil {
+		return err
+	}
+	e.repo = repo
+	return nil
+}
+
+func (e *env) close() {
+	if e.repo != nil {
+		e.repo.Close()
+	}
+}
+
+// print v as json when requested or call text to write the human readable version
+func (e *env) print(v interface{}, text func(w io.Writer)) error {
+	if e.json {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(e.stdout)
+	return nil
+}
+
+// resolve str as a BlobRef or as the name of a pointer
+func (e *env) resolve(str string) (isodb.BlobRef, error) {
+	if ref, err := isodb.ParseBlobRef(str); err == nil {
+		return ref, nil
+	}
+	return e.repo.GetPointer(str)
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
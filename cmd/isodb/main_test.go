@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "isodb-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var stderr bytes.Buffer
+	run := func(stdin string, args ...string) []byte {
+		t.Helper()
+		var out bytes.Buffer
+		e := &env{dir: dir, json: true, stdout: &out, stderr: &stderr, stdin: strings.NewReader(stdin)}
+		if err := e.open(args[0] == "init"); err != nil {
+			t.Fatal(err)
+		}
+		defer e.close()
+		if err := commands[args[0]].run(e, args[1:]); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		return out.Bytes()
+	}
+
+	if err := (&env{dir: dir}).open(false); err == nil {
+		t.Fatal("Commands other than init should not create a repository")
+	}
+	run("", "init", "-ref", "heads/main")
+	var put docOutput
+	if err := json.Unmarshal(run(`{"name": "bob"}`, "put", "-ref", "heads/main", "-set", "people"), &put); err != nil {
+		t.Fatal(err)
+	}
+	key := put.Key[strings.Index(put.Key, "/")+1:]
+
+	var get docOutput
+	if err := json.Unmarshal(run("", "get", "-ref", "heads/main", "-set", "people", "-key", key), &get); err != nil {
+		t.Fatal(err)
+	}
+	if string(get.Content) != `{"name": "bob"}` || get.Commit != put.Commit {
+		t.Fatalf("Unexpected document %+v", get)
+	}
+
+	var log []logEntry
+	if err := json.Unmarshal(run("", "log", "-ref", "heads/main"), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[0].Ref != put.Commit || len(log[1].Parents) != 0 {
+		t.Fatalf("Log should show the init and put commits got %+v", log)
+	}
+
+	run(`{"set": "people", "content": {"name": "alice"}}`, "import", "-ref", "heads/main")
+	if !strings.HasPrefix(stderr.String(), "imported 1 records") {
+		t.Fatalf("Import should report progress on stderr got %q", stderr.String())
+	}
+}
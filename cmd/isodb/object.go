@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/andrebq/isodb"
+)
+
+type (
+	objectOutput struct {
+		Ref     isodb.BlobRef
+		Content []byte
+	}
+)
+
+func initCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	ref := fs.String("ref", "", "create an empty commit and point ref to it")
+	fs.Parse(args)
+	out := refOutput{Name: *ref}
+	if *ref != "" {
+		commit, err := e.repo.Apply(isodb.NewChangeset())
+		if err != nil {
+			return err
+		}
+		if err := e.repo.UpdatePointer(*ref, commit, isodb.BlobRef{}); err != nil {
+			return err
+		}
+		out.Ref = commit
+	}
+	return e.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "initialized repository at %v\n", e.dir)
+	})
+}
+
+func catObjectCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("cat-object", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: isodb cat-object <ref>")
+	}
+	ref, err := isodb.ParseBlobRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := e.repo.GetBlob(ref)
+	if err != nil {
+		return err
+	}
+	out := objectOutput{Ref: ref, Content: b.Content}
+	return e.print(out, func(w io.Writer) {
+		w.Write(b.Content)
+	})
+}
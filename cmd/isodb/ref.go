@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/andrebq/isodb"
+)
+
+type (
+	refOutput struct {
+		Name string
+		Ref  isodb.BlobRef
+	}
+)
+
+func refCmd(e *env, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("ref: expecting get, set, list, delete, log or reset")
+	}
+	switch args[0] {
+	case "get":
+		return refGetCmd(e, args[1:])
+	case "set":
+		return refSetCmd(e, args[1:])
+	case "list":
+		return refListCmd(e, args[1:])
+	case "delete":
+		return refDeleteCmd(e, args[1:])
+	case "log":
+		return refLogCmd(e, args[1:])
+	case "reset":
+		return refResetCmd(e, args[1:])
+	}
+	return fmt.Errorf("ref: unknown subcommand %v", args[0])
+}
+
+func refGetCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref get", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: isodb ref get <name>")
+	}
+	ref, err := e.repo.GetPointer(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := refOutput{Name: fs.Arg(0), Ref: ref}
+	return e.print(out, func(w io.Writer) {
+		fmt.Fprintln(w, out.Ref)
+	})
+}
+
+func refSetCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref set", flag.ExitOnError)
+	old := fs.String("old", "", "expected current value, defaults to the value read before the update")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: isodb ref set [-old ref] <name> <ref>")
+	}
+	name := fs.Arg(0)
+	newRef, err := e.resolve(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	var oldRef isodb.BlobRef
+	if *old != "" {
+		oldRef, err = isodb.ParseBlobRef(*old)
+	} else {
+		oldRef, err = e.repo.GetPointer(name)
+		if err == isodb.ErrPointerNotFound {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.repo.UpdatePointer(name, newRef, oldRef); err != nil {
+		return err
+	}
+	out := refOutput{Name: name, Ref: newRef}
+	return e.print(out, func(w io.Writer) {
+		fmt.Fprintln(w, out.Ref)
+	})
+}
+
+func refListCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref list", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "only list pointers starting with prefix")
+	fs.Parse(args)
+	ptrs, err := e.repo.ListPointers(*prefix)
+	if err != nil {
+		return err
+	}
+	out := make([]refOutput, 0, len(ptrs))
+	for _, p := range ptrs {
+		out = append(out, refOutput{Name: p.Name, Ref: p.Ref})
+	}
+	return e.print(out, func(w io.Writer) {
+		for _, r := range out {
+			fmt.Fprintf(w, "%v\t%v\n", r.Ref, r.Name)
+		}
+	})
+}
+
+func refDeleteCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref delete", flag.ExitOnError)
+	old := fs.String("old", "", "expected current value, defaults to the value read before the removal")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: isodb ref delete [-old ref] <name>")
+	}
+	name := fs.Arg(0)
+	var oldRef isodb.BlobRef
+	var err error
+	if *old != "" {
+		oldRef, err = isodb.ParseBlobRef(*old)
+	} else {
+		oldRef, err = e.repo.GetPointer(name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.repo.DeletePointer(name, oldRef); err != nil {
+		return err
+	}
+	out := refOutput{Name: name, Ref: oldRef}
+	return e.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted %v (was %v)\n", out.Name, out.Ref)
+	})
+}
+
+func refLogCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref log", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: isodb ref log <name>")
+	}
+	entries, err := e.repo.PointerLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return e.print(entries, func(w io.Writer) {
+		for _, le := range entries {
+			fmt.Fprintf(w, "%v\t%v\t%v -> %v\t%v\t%v\n", le.Seq, le.Time.Format(time.RFC3339), le.Old, le.New, le.Actor, le.Reason)
+		}
+	})
+}
+
+func refResetCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("ref reset", flag.ExitOnError)
+	actor := fs.String("actor", "isodb", "actor recorded in the pointer log")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: isodb ref reset [-actor name] <name> <seq>")
+	}
+	seq, err := strconv.ParseUint(fs.Arg(1), 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := e.repo.ResetPointer(fs.Arg(0), seq, *actor); err != nil {
+		return err
+	}
+	return refGetCmd(e, fs.Args()[:1])
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/andrebq/isodb/httpapi"
+)
+
+func serveCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8040", "address to listen for http requests")
+	fs.Parse(args)
+	fmt.Fprintf(e.stderr, "serving %v at http://%v\n", e.dir, *addr)
+	return http.ListenAndServe(*addr, httpapi.NewHandler(e.repo))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andrebq/isodb"
+)
+
+func importCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	ref := fs.String("ref", "heads/main", "pointer advanced after every batch")
+	file := fs.String("file", "-", "file with newline-delimited json records, - reads from stdin")
+	id := fs.String("id", "", "id of the import job, defaults to the pointer")
+	resume := fs.Bool("resume", false, "skip records committed by a previous import with the same id")
+	batch := fs.Int("batch", 0, "maximum number of records per commit")
+	fs.Parse(args)
+	in := e.stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	progress, err := e.repo.Import(in, isodb.ImportOptions{
+		Pointer:   *ref,
+		ID:        *id,
+		Resume:    *resume,
+		BatchSize: *batch,
+		Progress: func(p isodb.ImportProgress) {
+			fmt.Fprintf(e.stderr, "imported %v records, commit %v\n", p.Records, p.Commit)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return e.print(progress, func(w io.Writer) {
+		fmt.Fprintf(w, "%v records, %v commits, head %v\n", progress.Records, progress.Commits, progress.Commit)
+	})
+}
+
+func exportCmd(e *env, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	ref := fs.String("ref", "heads/main", "pointer or commit to export")
+	format := fs.String("format", string(isodb.ExportNDJSON), "output format: ndjson or tar")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: isodb export [flags] [set...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	commit, err := e.resolve(*ref)
+	if err != nil {
+		return err
+	}
+	return e.repo.Export(commit, e.stdout, isodb.ExportFormat(*format), fs.Args()...)
+}
@@ -0,0 +1,154 @@
+package isodb
+
+type (
+	// fileLoader returns the File for the given ref, empty refs must return an empty File
+	fileLoader interface {
+		loadFile(ref BlobRef) (File, error)
+	}
+
+	// DocumentChange describes how a document changed between two commits.
+	//
+	// Old is empty for new documents and New is empty for removed documents.
+	DocumentChange struct {
+		Key DocumentKey
+		Old BlobRef
+		New BlobRef
+	}
+)
+
+// Diff returns the list of documents which differ between the commits from and to,
+// sorted by their path on the tree.
+//
+// from can be empty, in which case every document in to is reported as new.
+func (r *Repo) Diff(from, to BlobRef) ([]DocumentChange, error) {
+	fromRoot, err := r.commitRoot(from)
+	if err != nil {
+		return nil, err
+	}
+	toRoot, err := r.commitRoot(to)
+	if err != nil {
+		return nil, err
+	}
+	var changes []DocumentChange
+	err = diffFiles(r, fromRoot, toRoot, nil, func(c DocumentChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	return changes, err
+}
+
+// commitRoot returns the ref of the root folder for the given commit or empty if commit is empty
+func (r *Repo) commitRoot(commit BlobRef) (BlobRef, error) {
+	if commit.IsZero() {
+		return BlobRef{}, nil
+	}
+	c, err := r.GetCommit(commit)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	return c.Folder, nil
+}
+
+// loadFile returns the file pointed by ref or an empty file if ref is empty
+func (r *Repo) loadFile(ref BlobRef) (File, error) {
+	if ref.IsZero() {
+		return File{}, nil
+	}
+	return r.GetFile(ref)
+}
+
+// diffFiles compares the trees starting at from and to, calling fn for every leaf which differs
+func diffFiles(l fileLoader, from, to BlobRef, path []string, fn func(DocumentChange) error) error {
+	if from == to {
+		return nil
+	}
+	fromFile, err := l.loadFile(from)
+	if err != nil {
+		return err
+	}
+	toFile, err := l.loadFile(to)
+	if err != nil {
+		return err
+	}
+	if fromFile.Leaf || toFile.Leaf {
+		key, err := keyFromPaths(path)
+		if err != nil {
+			return err
+		}
+		c := DocumentChange{Key: key, Old: fromFile.GetFileContent(), New: toFile.GetFileContent()}
+		if c.Old == c.New {
+			return nil
+		}
+		return fn(c)
+	}
+
+	a, b := fromFile.Children, toFile.Children
+	for len(a) > 0 || len(b) > 0 {
+		var name string
+		var fromRef, toRef BlobRef
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].Name < b[0].Name):
+			name, fromRef = a[0].Name, a[0].Ref
+			a = a[1:]
+		case len(a) == 0 || b[0].Name < a[0].Name:
+			name, toRef = b[0].Name, b[0].Ref
+			b = b[1:]
+		default:
+			name, fromRef, toRef = a[0].Name, a[0].Ref, b[0].Ref
+			a, b = a[1:], b[1:]
+		}
+		if len(path) == 0 && reservedName(name) {
+			continue
+		}
+		err := diffFiles(l, fromRef, toRef, append(path[:len(path):len(path)], name), fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listSet calls fn for every document in set, sorted by K
+func listSet(l fileLoader, root BlobRef, set string, fn func(DocumentKey) error) error {
+	return walkSet(l, root, set, func(k DocumentKey, _ BlobRef) error {
+		return fn(k)
+	})
+}
+
+// walkSet calls fn with the key and content of every document in set, sorted by K
+func walkSet(l fileLoader, root BlobRef, set string, fn func(DocumentKey, BlobRef) error) error {
+	file, err := l.loadFile(root)
+	if err != nil {
+		return err
+	}
+	e, i := file.Children.FindByName(set)
+	if i.NotFound() {
+		return nil
+	}
+	return diffFiles(l, BlobRef{}, e.Ref, []string{set}, func(c DocumentChange) error {
+		return fn(c.Key, c.New)
+	})
+}
+
+// findContent returns the ref of the content of the document, starting from root
+func findContent(l fileLoader, root BlobRef, key DocumentKey) (BlobRef, error) {
+	file, err := l.loadFile(root)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	for _, p := range key.paths() {
+		e, i := file.Children.FindByName(p)
+		if i.NotFound() {
+			return BlobRef{}, ErrDocumentNotFound
+		}
+		file, err = l.loadFile(e.Ref)
+		if err != nil {
+			return BlobRef{}, err
+		}
+	}
+	content := file.GetFileContent()
+	if content.IsZero() {
+		return BlobRef{}, ErrDocumentNotFound
+	}
+	return content, nil
+}
@@ -1,6 +1,9 @@
 package isodb
 
-import "github.com/segmentio/ksuid"
+import (
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+)
 
 type (
 	// DocumentKey contains the identification of a document
@@ -26,3 +29,20 @@ func (d DocumentKey) paths() []string {
 	p = append(p, d.Set, str[:2], str[2:4], str[4:6], str[6:8], str[8:10], str[10:12], str)
 	return p
 }
+
+// String returns the key as Set/K
+func (d DocumentKey) String() string {
+	return d.Set + "/" + d.K.String()
+}
+
+// keyFromPaths is the inverse of paths
+func keyFromPaths(p []string) (DocumentKey, error) {
+	if len(p) < 2 {
+		return DocumentKey{}, errors.Errorf("isodb: invalid document path %v", p)
+	}
+	k, err := ksuid.Parse(p[len(p)-1])
+	if err != nil {
+		return DocumentKey{}, errors.Wrapf(err, "isodb: invalid document path %v", p)
+	}
+	return DocumentKey{Set: p[0], K: k}, nil
+}
@@ -30,20 +30,21 @@ func (el EdgeList) FindByName(name string) (Edge, Idx) {
 	return Edge{}, IdxNotFound
 }
 
-// Insert will update this EdgeList to include the given edge, returns the updated list if the edge is new
-// or the same list if the edge already exists.
+// Insert returns a copy of this EdgeList including the given edge, replacing the edge with the
+// same name if it exists (in which case its index is returned).
 //
-// The array is sorted after this operation regardless of it being changed or not.
+// The returned list is always sorted and el is never modified, so lists shared between
+// decoded objects are safe to use.
 func (el EdgeList) Insert(e Edge) (EdgeList, Idx) {
-	el.SortInPlace()
-	for i, v := range el {
+	copied := make(EdgeList, 0, len(el)+1)
+	copied = append(copied, el...)
+	copied.SortInPlace()
+	for i, v := range copied {
 		if v.Name == e.Name {
-			(el)[i] = e
-			return el, Idx(i)
+			copied[i] = e
+			return copied, Idx(i)
 		}
 	}
-	copied := make(EdgeList, 0, len(el))
-	copied = append(copied, el...)
 	copied = append(copied, e)
 	copied.SortInPlace()
 
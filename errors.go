@@ -1,7 +1,27 @@
 package isodb
 
+import (
+	"fmt"
+	"strings"
+)
+
 type (
 	strErr string
+
+	// ErrCorruptBlob indicates that the content of a blob does not match its ref
+	ErrCorruptBlob struct {
+		// Expected ref, the one used to find the blob
+		Expected BlobRef
+		// Actual ref computed from the content
+		Actual BlobRef
+	}
+
+	// ErrInvalidDefinition indicates that a document of a system set (IndexesSet,
+	// ValidatorsSet, SchemasSet or ResolversSet) cannot be used
+	ErrInvalidDefinition struct {
+		Key    DocumentKey
+		Reason string
+	}
 )
 
 const (
@@ -14,3 +34,16 @@ const (
 func (s strErr) Error() string {
 	return string(s)
 }
+
+// invalidDefinition returns an ErrInvalidDefinition for k caused by err
+func invalidDefinition(k DocumentKey, err error) error {
+	return ErrInvalidDefinition{Key: k, Reason: strings.TrimPrefix(err.Error(), "isodb: ")}
+}
+
+func (e ErrInvalidDefinition) Error() string {
+	return fmt.Sprintf("isodb: invalid definition %v: %v", e.Key, e.Reason)
+}
+
+func (e ErrCorruptBlob) Error() string {
+	return fmt.Sprintf("isodb: corrupt blob, expecting %v got %v", e.Expected, e.Actual)
+}
@@ -0,0 +1,107 @@
+package isodb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+type (
+	// ExportFormat lists the formats supported by Export
+	ExportFormat string
+)
+
+const (
+	// ExportNDJSON writes one Record per line
+	ExportNDJSON = ExportFormat("ndjson")
+
+	// ExportTar writes a tar archive with one file per document named Set/K
+	ExportTar = ExportFormat("tar")
+
+	// ErrInvalidExportFormat indicates an unknown ExportFormat
+	ErrInvalidExportFormat = strErr("isodb: invalid export format")
+)
+
+// Export writes every document in commit to w using the given format, documents are
+// written as they are read from the tree so the whole commit is never kept in memory.
+//
+// Importing the output of ExportNDJSON recreates the same blobs, JSON documents which
+// are not compact are written as content_base64.
+//
+// If sets are provided, only documents in those sets are exported.
+func (r *Repo) Export(commit BlobRef, w io.Writer, format ExportFormat, sets ...string) error {
+	var write func(DocumentKey, Blob) error
+	var done func() error
+	switch format {
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		var compact bytes.Buffer
+		write = func(k DocumentKey, b Blob) error {
+			rec := Record{Set: k.Set, Key: k.K.String()}
+			// the encoder compacts Content, so only documents which are already compact
+			// are written as JSON, otherwise the imported blob would be different
+			compact.Reset()
+			if json.Compact(&compact, b.Content) == nil && bytes.Equal(compact.Bytes(), b.Content) {
+				rec.Content = json.RawMessage(b.Content)
+			} else {
+				rec.ContentBase64 = b.Content
+			}
+			return enc.Encode(rec)
+		}
+		done = func() error { return nil }
+	case ExportTar:
+		tw := tar.NewWriter(w)
+		write = func(k DocumentKey, b Blob) error {
+			err := tw.WriteHeader(&tar.Header{
+				Name:     k.String(),
+				Mode:     0644,
+				Size:     int64(len(b.Content)),
+				ModTime:  k.K.Time(),
+				Typeflag: tar.TypeReg,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(b.Content)
+			return err
+		}
+		done = tw.Close
+	default:
+		return ErrInvalidExportFormat
+	}
+
+	rootRef, err := r.commitRoot(commit)
+	if err != nil {
+		return err
+	}
+	root, err := r.loadFile(rootRef)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		for _, e := range root.Children {
+			if !reservedName(e.Name) {
+				sets = append(sets, e.Name)
+			}
+		}
+	}
+	for _, set := range sets {
+		e, idx := root.Children.FindByName(set)
+		if idx.NotFound() {
+			continue
+		}
+		err := diffFiles(r, BlobRef{}, e.Ref, []string{set}, func(c DocumentChange) error {
+			b, err := r.GetBlob(c.New)
+			if err != nil {
+				return err
+			}
+			return write(c.Key, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return done()
+}
@@ -0,0 +1,70 @@
+package isodb
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	repo := newRepo(t)
+	bob, alice := NewRandomKey("people"), NewRandomKey("people")
+	file := NewRandomKey("files")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString(`{"name":"<b>","n":1.0}`))
+	cs.Put(alice, NewBlobString(`{"name": "alice"}`))
+	cs.Put(file, Blob{Content: []byte{0, 1, 2}})
+	commit, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := repo.Export(commit, &buf, ExportNDJSON); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`{"set":"files","ksuid":"` + file.K.String() + `","content_base64":"AAEC"}`,
+		`{"set":"people","ksuid":"` + bob.K.String() + `","content":{"name":"<b>","n":1.0}}`,
+		`{"set":"people","ksuid":"` + alice.K.String() + `","content_base64":"eyJuYW1lIjogImFsaWNlIn0="}`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Output should contain %v got:\n%v", line, buf.String())
+		}
+	}
+
+	// the backup recreates the same objects
+	other := newRepo(t)
+	progress, err := other.Import(&buf, ImportOptions{Pointer: "heads/main"})
+	if err != nil {
+		t.Fatal(err)
+	} else if progress.Commit != commit {
+		t.Fatalf("Import should recreate %v got %v", commit, progress.Commit)
+	}
+
+	buf.Reset()
+	if err := repo.Export(commit, &buf, ExportTar, "people"); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(content)
+	}
+	if len(entries) != 2 || entries[bob.String()] != `{"name":"<b>","n":1.0}` || entries[alice.String()] == "" {
+		t.Fatalf("Only the people set should be exported, got %v", entries)
+	}
+}
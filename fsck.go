@@ -0,0 +1,197 @@
+package isodb
+
+import (
+	"sort"
+	"strings"
+)
+
+type (
+	// FsckReport contains the result of a Fsck call
+	FsckReport struct {
+		// Pointers checked
+		Pointers int
+		// Commits checked
+		Commits int
+		// Files checked
+		Files int
+		// Blobs (document content) checked
+		Blobs int
+		// Problems found during the check
+		Problems []FsckProblem
+	}
+
+	// FsckProblem describes a single inconsistency found by Fsck
+	FsckProblem struct {
+		// Kind of the problem
+		Kind FsckKind
+		// Ref of the object with the problem (empty for pointers)
+		Ref BlobRef `json:",omitempty"`
+		// Path to the object, starting from the pointer which referenced it
+		Path string
+		// Detail with a human readable explanation
+		Detail string `json:",omitempty"`
+	}
+
+	// FsckKind lists the problems reported by Fsck
+	FsckKind string
+
+	fsck struct {
+		repo    *Repo
+		report  *FsckReport
+		visited map[BlobRef]bool
+	}
+)
+
+const (
+	// FsckInvalidPointer the value of a pointer cannot be decoded
+	FsckInvalidPointer = FsckKind("invalid-pointer")
+	// FsckMissingObject the object is referenced but not present
+	FsckMissingObject = FsckKind("missing-object")
+	// FsckHashMismatch the content of the object does not match its ref
+	FsckHashMismatch = FsckKind("hash-mismatch")
+	// FsckInvalidCommit the object cannot be decoded as a Commit
+	FsckInvalidCommit = FsckKind("invalid-commit")
+	// FsckInvalidFile the object cannot be decoded as a File
+	FsckInvalidFile = FsckKind("invalid-file")
+	// FsckUnsortedEdges the children of a File are not sorted by name
+	FsckUnsortedEdges = FsckKind("unsorted-edges")
+	// FsckMissingContent a leaf File does not have a blob edge
+	FsckMissingContent = FsckKind("missing-content")
+)
+
+// OK returns true if no problems were found
+func (fr *FsckReport) OK() bool {
+	return len(fr.Problems) == 0
+}
+
+// Fsck walks every pointer, commit, file and content blob reachable from them
+// and reports any inconsistency found.
+//
+// The returned error indicates that the check itself could not be completed,
+// problems with the data are reported in FsckReport.
+func (r *Repo) Fsck() (*FsckReport, error) {
+	f := &fsck{
+		repo:    r,
+		report:  &FsckReport{},
+		visited: make(map[BlobRef]bool),
+	}
+	ptrs, err := r.kv.Keys(pointerPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range ptrs {
+		name := strings.TrimPrefix(k, pointerPrefix)
+		f.report.Pointers++
+		val, err := r.kv.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		var ref BlobRef
+		if err := defaultCodec.decode(&ref, val); err != nil || !ref.Alg.valid() {
+			f.problem(FsckInvalidPointer, BlobRef{}, name, "unable to decode pointer")
+			continue
+		}
+		if err := f.commit(ref, name); err != nil {
+			return nil, err
+		}
+	}
+	return f.report, nil
+}
+
+func (f *fsck) problem(kind FsckKind, ref BlobRef, path, detail string) {
+	f.report.Problems = append(f.report.Problems, FsckProblem{
+		Kind:   kind,
+		Ref:    ref,
+		Path:   path,
+		Detail: detail,
+	})
+}
+
+// read the raw blob and checks its hash, returns false if the object cannot be used
+func (f *fsck) read(ref BlobRef, path string) (Blob, bool, error) {
+	// Has reports empty values as missing, so empty documents are read with Get
+	b, err := f.repo.kv.Get(ref.String())
+	if err == ErrKeyNotFound {
+		f.problem(FsckMissingObject, ref, path, "")
+		return Blob{}, false, nil
+	} else if err != nil {
+		return Blob{}, false, err
+	}
+	if err := verifyBlob(ref, b); err != nil {
+		f.problem(FsckHashMismatch, ref, path, err.Error())
+	}
+	return b, true, nil
+}
+
+func (f *fsck) commit(ref BlobRef, path string) error {
+	if f.visited[ref] {
+		return nil
+	}
+	f.visited[ref] = true
+	f.report.Commits++
+	b, ok, err := f.read(ref, path)
+	if err != nil || !ok {
+		return err
+	}
+	var c Commit
+	if err := c.FromBlob(b); err != nil {
+		f.problem(FsckInvalidCommit, ref, path, err.Error())
+		return nil
+	} else if c.Folder.IsZero() {
+		f.problem(FsckInvalidCommit, ref, path, "commit without folder")
+		return nil
+	}
+	if err := f.file(c.Folder, path+"@"+ref.String()); err != nil {
+		return err
+	}
+	for _, p := range c.Parents {
+		if err := f.commit(p, path+"^"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) file(ref BlobRef, path string) error {
+	if f.visited[ref] {
+		return nil
+	}
+	f.visited[ref] = true
+	f.report.Files++
+	b, ok, err := f.read(ref, path)
+	if err != nil || !ok {
+		return err
+	}
+	var file File
+	if err := file.FromBlob(b); err != nil {
+		f.problem(FsckInvalidFile, ref, path, err.Error())
+		return nil
+	}
+	if !sort.IsSorted(file.Children) {
+		f.problem(FsckUnsortedEdges, ref, path, "")
+	}
+	if file.Leaf {
+		content := file.GetFileContent()
+		if content.IsZero() {
+			f.problem(FsckMissingContent, ref, path, "")
+			return nil
+		}
+		return f.content(content, path+"/blob")
+	}
+	for _, e := range file.Children {
+		if err := f.file(e.Ref, path+"/"+e.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) content(ref BlobRef, path string) error {
+	if f.visited[ref] {
+		return nil
+	}
+	f.visited[ref] = true
+	f.report.Blobs++
+	_, _, err := f.read(ref, path)
+	return err
+}
@@ -3,7 +3,7 @@ module github.com/andrebq/isodb
 go 1.13
 
 require (
-	github.com/dgraph-io/badger v1.6.0
+	github.com/dgraph-io/badger v1.6.2
 	github.com/pkg/errors v0.8.1
 	github.com/segmentio/ksuid v1.0.2
 )
@@ -0,0 +1,98 @@
+package isodb
+
+type (
+	// Hooks are callbacks invoked on the write path of a Repo, nil callbacks are ignored.
+	//
+	// Hooks are called synchronously by the goroutine doing the write, in the order
+	// they were added.
+	Hooks struct {
+		// PreApply is called by Apply before the commit is built, returning an error
+		// aborts Apply with ErrVetoed
+		PreApply func(cs *Changeset) error
+
+		// PostApply is called once the commit created by Apply is stored
+		PostApply func(commit BlobRef)
+
+		// PreUpdatePointer is called for every pointer change (UpdatePointer, UpdatePointers,
+		// DeletePointer and ResetPointer) inside the KV transaction, returning an error aborts
+		// the whole transaction with ErrVetoed. Retried transactions only call it for
+		// changes it did not accept yet, it must not change the Repo.
+		PreUpdatePointer func(u PointerUpdate) error
+
+		// PostUpdatePointer is called for every pointer change once the transaction
+		// is committed
+		PostUpdatePointer func(u PointerUpdate)
+	}
+
+	// ErrVetoed is returned when a PreApply or PreUpdatePointer hook rejects a write,
+	// Err is the error returned by the hook
+	ErrVetoed struct {
+		Err error
+	}
+)
+
+// AddHooks registers h to be called on every write to the Repo
+func (r *Repo) AddHooks(h Hooks) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, h)
+}
+
+// getHooks returns the hooks registered so far, the list is never modified in place
+func (r *Repo) getHooks() []Hooks {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hooks
+}
+
+func (r *Repo) preApply(cs *Changeset) error {
+	for _, h := range r.getHooks() {
+		if h.PreApply == nil {
+			continue
+		}
+		if err := h.PreApply(cs); err != nil {
+			return ErrVetoed{Err: err}
+		}
+	}
+	return nil
+}
+
+func (r *Repo) postApply(commit BlobRef) {
+	for _, h := range r.getHooks() {
+		if h.PostApply != nil {
+			h.PostApply(commit)
+		}
+	}
+}
+
+func (r *Repo) preUpdatePointer(u PointerUpdate) error {
+	for _, h := range r.getHooks() {
+		if h.PreUpdatePointer == nil {
+			continue
+		}
+		if err := h.PreUpdatePointer(u); err != nil {
+			return ErrVetoed{Err: err}
+		}
+	}
+	return nil
+}
+
+func (r *Repo) postUpdatePointer(updates []PointerUpdate) {
+	for _, h := range r.getHooks() {
+		if h.PostUpdatePointer == nil {
+			continue
+		}
+		for _, u := range updates {
+			h.PostUpdatePointer(u)
+		}
+	}
+}
+
+func (e ErrVetoed) Error() string {
+	return "isodb: vetoed by hook: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the hook
+func (e ErrVetoed) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,130 @@
+package isodb
+
+import (
+	"errors"
+	"testing"
+
+	badger "github.com/dgraph-io/badger"
+)
+
+func TestHooks(t *testing.T) {
+	repo := newRepo(t)
+	var log []string
+	errVeto := errors.New("veto")
+	repo.AddHooks(Hooks{
+		PreApply: func(cs *Changeset) error {
+			for _, k := range cs.Keys() {
+				if k.Set == "forbidden" {
+					return errVeto
+				}
+			}
+			log = append(log, "pre-apply")
+			return nil
+		},
+		PostApply: func(commit BlobRef) {
+			log = append(log, "post-apply "+commit.String())
+		},
+		PreUpdatePointer: func(u PointerUpdate) error {
+			if u.Name == "heads/locked" {
+				return errVeto
+			}
+			return nil
+		},
+	})
+	repo.AddHooks(Hooks{
+		PostUpdatePointer: func(u PointerUpdate) {
+			log = append(log, "post-update "+u.Name+" "+u.New.String())
+		},
+	})
+	expect := func(expected ...string) {
+		t.Helper()
+		if len(log) != len(expected) {
+			t.Fatalf("Expecting %v got %v", expected, log)
+		}
+		for i := range log {
+			if log[i] != expected[i] {
+				t.Fatalf("Expecting %v got %v", expected, log)
+			}
+		}
+		log = nil
+	}
+
+	cs := NewChangeset()
+	cs.Put(NewRandomKey("forbidden"), NewBlobString("nope"))
+	if _, err := repo.Apply(cs); !errors.Is(err, errVeto) {
+		t.Fatalf("PreApply should veto the changeset got %v", err)
+	}
+	expect()
+
+	cs = NewChangeset()
+	cs.Put(NewRandomKey("people"), NewBlobString("bob"))
+	commit, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("pre-apply", "post-apply "+commit.String())
+
+	if err := repo.UpdatePointer("heads/locked", commit, BlobRef{}); !errors.Is(err, errVeto) {
+		t.Fatalf("PreUpdatePointer should veto the update got %v", err)
+	}
+	if _, err := repo.GetPointer("heads/locked"); err != ErrPointerNotFound {
+		t.Fatalf("Vetoed pointer should not exist got %v", err)
+	}
+	err = repo.UpdatePointers([]PointerUpdate{
+		{Name: "heads/main", New: commit},
+		{Name: "heads/locked", New: commit},
+	})
+	if _, ok := err.(ErrVetoed); !ok {
+		t.Fatalf("PreUpdatePointer should veto the whole transaction got %v", err)
+	}
+	if _, err := repo.GetPointer("heads/main"); err != ErrPointerNotFound {
+		t.Fatalf("Vetoed transaction should not update pointers got %v", err)
+	}
+	expect()
+
+	if err := repo.UpdatePointer("heads/main", commit, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeletePointer("heads/main", commit); err != nil {
+		t.Fatal(err)
+	}
+	expect("post-update heads/main "+commit.String(), "post-update heads/main "+BlobRef{}.String())
+}
+
+func TestHooksRetriedTx(t *testing.T) {
+	repo := newRepo(t)
+	calls := 0
+	repo.AddHooks(Hooks{
+		PreUpdatePointer: func(u PointerUpdate) error {
+			calls++
+			return nil
+		},
+	})
+	commit, err := repo.Apply(NewChangeset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	err = repo.pointerTx(func(ptx *pointerTx) error {
+		if err := ptx.update(PointerUpdate{Name: "heads/main", New: commit}); err != nil {
+			return err
+		}
+		if attempts++; attempts < 3 {
+			return badger.ErrConflict
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || calls != 1 {
+		t.Fatalf("Hooks should be called once for %v attempts got %v calls", attempts, calls)
+	}
+
+	err = repo.pointerTx(func(ptx *pointerTx) error {
+		return badger.ErrConflict
+	})
+	if err != badger.ErrConflict {
+		t.Fatalf("Conflicts should not be retried forever got %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+// Package httpapi exposes an isodb.Repo as a REST/JSON API.
+//
+// Routes:
+//
+//	GET    /refs?prefix={prefix}                   list pointers
+//	GET    /refs/{name}                            current value of the pointer
+//	PUT    /refs/{name}                            update the pointer (CAS via If-Match)
+//	DELETE /refs/{name}                            remove the pointer (CAS via If-Match)
+//	GET    /refs/{name}/sets/{set}/docs            list documents in the set
+//	POST   /refs/{name}/sets/{set}/docs            create a document with a random key
+//	GET    /refs/{name}/sets/{set}/docs/{ksuid}    read a document
+//	PUT    /refs/{name}/sets/{set}/docs/{ksuid}    write a document
+//	DELETE /refs/{name}/sets/{set}/docs/{ksuid}    remove a document
+//	POST   /refs/{name}/sets/{set}/query           query the set, the body is an isodb.Query
+//	GET    /commits/{ref}                          read a commit
+//	GET    /commits/{ref}/sets/{set}/docs[/{ksuid}] read documents from a commit
+//	POST   /commits/{ref}/sets/{set}/query         query the set at the commit
+//
+// Every write creates a new commit and advances the pointer with UpdatePointer semantics.
+// Writes accept an If-Match header with the BlobRef of the commit the client expects the
+// pointer to have, responses carry the current commit in the ETag header.
+package httpapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andrebq/isodb"
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	handler struct {
+		repo *isodb.Repo
+	}
+
+	// route is the parsed version of a request path
+	route struct {
+		// ref is the name of the pointer or the commit ref
+		ref    string
+		commit bool
+		set    string
+		key    string
+		// hasDocs is true if the path includes the /docs segment
+		hasDocs bool
+		// query is true if the path ends with the /query segment
+		query bool
+	}
+
+	refBody struct {
+		Name string
+		Ref  isodb.BlobRef
+	}
+
+	docBody struct {
+		Set    string
+		Key    string
+		Commit isodb.BlobRef
+	}
+
+	errBody struct {
+		Error string
+	}
+
+	httpErr struct {
+		status int
+		msg    string
+	}
+)
+
+// NewHandler returns a http.Handler serving the given repo
+func NewHandler(repo *isodb.Repo) http.Handler {
+	return &handler{repo: repo}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/refs" || req.URL.Path == "/refs/" {
+		if err := h.listPointers(w, req); err != nil {
+			writeErr(w, err)
+		}
+		return
+	}
+	rt, err := parseRoute(req.URL.Path)
+	if err == nil {
+		err = h.serve(w, req, rt)
+	}
+	if err != nil {
+		writeErr(w, err)
+	}
+}
+
+func (h *handler) serve(w http.ResponseWriter, req *http.Request, rt route) error {
+	switch {
+	case rt.query:
+		return h.query(w, req, rt)
+	case rt.commit:
+		if req.Method != http.MethodGet {
+			return errMethod
+		}
+		ref, err := isodb.ParseBlobRef(rt.ref)
+		if err != nil {
+			return badRequest(err)
+		}
+		if !rt.hasDocs {
+			c, err := h.repo.GetCommit(ref)
+			if err != nil {
+				return err
+			}
+			w.Header().Set("ETag", etag(ref))
+			return writeJSON(w, http.StatusOK, c)
+		}
+		return h.readDocs(w, ref, rt)
+	case !rt.hasDocs:
+		return h.pointer(w, req, rt)
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		ref, err := h.repo.GetPointer(rt.ref)
+		if err != nil {
+			return err
+		}
+		return h.readDocs(w, ref, rt)
+	case http.MethodPost:
+		if rt.key != "" {
+			return errMethod
+		}
+		return h.write(w, req, rt, isodb.NewRandomKey(rt.set))
+	case http.MethodPut, http.MethodDelete:
+		if rt.key == "" {
+			return errMethod
+		}
+		k, err := ksuid.Parse(rt.key)
+		if err != nil {
+			return badRequest(err)
+		}
+		return h.write(w, req, rt, isodb.DocumentKey{Set: rt.set, K: k})
+	}
+	return errMethod
+}
+
+func (h *handler) pointer(w http.ResponseWriter, req *http.Request, rt route) error {
+	switch req.Method {
+	case http.MethodGet:
+		ref, err := h.repo.GetPointer(rt.ref)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", etag(ref))
+		return writeJSON(w, http.StatusOK, refBody{Name: rt.ref, Ref: ref})
+	case http.MethodPut:
+		var body refBody
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return badRequest(err)
+		} else if body.Ref.IsZero() {
+			return httpErr{status: http.StatusBadRequest, msg: "httpapi: ref is required, use DELETE to remove pointers"}
+		}
+		old, err := ifMatch(req)
+		if err != nil {
+			return err
+		}
+		if err := h.repo.UpdatePointer(rt.ref, body.Ref, old); err != nil {
+			return err
+		}
+		w.Header().Set("ETag", etag(body.Ref))
+		return writeJSON(w, http.StatusOK, refBody{Name: rt.ref, Ref: body.Ref})
+	case http.MethodDelete:
+		old, err := ifMatch(req)
+		if err != nil {
+			return err
+		} else if old.IsZero() {
+			return httpErr{status: http.StatusPreconditionRequired, msg: "httpapi: If-Match is required"}
+		}
+		if err := h.repo.DeletePointer(rt.ref, old); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethod
+}
+
+func (h *handler) listPointers(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return errMethod
+	}
+	ptrs, err := h.repo.ListPointers(req.URL.Query().Get("prefix"))
+	if err != nil {
+		return err
+	}
+	refs := make([]refBody, 0, len(ptrs))
+	for _, p := range ptrs {
+		refs = append(refs, refBody{Name: p.Name, Ref: p.Ref})
+	}
+	return writeJSON(w, http.StatusOK, refs)
+}
+
+func (h *handler) readDocs(w http.ResponseWriter, commit isodb.BlobRef, rt route) error {
+	w.Header().Set("ETag", etag(commit))
+	if rt.key == "" {
+		keys, err := h.repo.List(commit, rt.set)
+		if err != nil {
+			return err
+		}
+		docs := make([]docBody, 0, len(keys))
+		for _, k := range keys {
+			docs = append(docs, docBody{Set: k.Set, Key: k.K.String(), Commit: commit})
+		}
+		return writeJSON(w, http.StatusOK, docs)
+	}
+	k, err := ksuid.Parse(rt.key)
+	if err != nil {
+		return badRequest(err)
+	}
+	content, err := h.repo.GetContentAtKey(commit, isodb.DocumentKey{Set: rt.set, K: k})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if json.Valid(content.Content) {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(content.Content)
+	return err
+}
+
+func (h *handler) query(w http.ResponseWriter, req *http.Request, rt route) error {
+	if req.Method != http.MethodPost {
+		return errMethod
+	}
+	var q isodb.Query
+	if err := json.NewDecoder(req.Body).Decode(&q); err != nil {
+		return badRequest(err)
+	}
+	var commit isodb.BlobRef
+	var err error
+	if rt.commit {
+		if commit, err = isodb.ParseBlobRef(rt.ref); err != nil {
+			return badRequest(err)
+		}
+	} else if commit, err = h.repo.GetPointer(rt.ref); err != nil {
+		return err
+	}
+	results, err := h.repo.Query(commit, rt.set, q)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(commit))
+	return writeJSON(w, http.StatusOK, results)
+}
+
+// write (or remove) the document and advance the pointer, the pointer must match If-Match
+// or, when the header is absent, the value read before the change.
+func (h *handler) write(w http.ResponseWriter, req *http.Request, rt route, key isodb.DocumentKey) error {
+	old, err := ifMatch(req)
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("If-Match") == "" {
+		old, err = h.repo.GetPointer(rt.ref)
+		if err != nil && err != isodb.ErrPointerNotFound {
+			return err
+		}
+	}
+	var cs *isodb.Changeset
+	if old.IsZero() {
+		cs = isodb.NewChangeset()
+	} else {
+		cs = isodb.NewChangeset(old)
+	}
+
+	status := http.StatusOK
+	if req.Method == http.MethodDelete {
+		if old.IsZero() {
+			return isodb.ErrDocumentNotFound
+		}
+		if _, err := h.repo.GetContentAtKey(old, key); err != nil {
+			return err
+		}
+		cs.Delete(key)
+	} else {
+		content, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return badRequest(err)
+		}
+		if req.Method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		cs.Put(key, isodb.Blob{Content: content})
+	}
+
+	commit, err := h.repo.Apply(cs)
+	if err != nil {
+		return err
+	}
+	if err := h.repo.UpdatePointer(rt.ref, commit, old); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(commit))
+	return writeJSON(w, status, docBody{Set: key.Set, Key: key.K.String(), Commit: commit})
+}
+
+// parseRoute splits the path into its components, pointer names might contain slashes
+// so the path is split around the /sets/ and /docs segments
+func parseRoute(path string) (route, error) {
+	var rt route
+	switch {
+	case strings.HasPrefix(path, "/refs/"):
+		path = strings.TrimPrefix(path, "/refs/")
+	case strings.HasPrefix(path, "/commits/"):
+		path = strings.TrimPrefix(path, "/commits/")
+		rt.commit = true
+	default:
+		return rt, errNotFound
+	}
+	idx := strings.Index(path, "/sets/")
+	if idx < 0 {
+		rt.ref = path
+	} else {
+		rt.ref = path[:idx]
+		parts := strings.Split(path[idx+len("/sets/"):], "/")
+		switch {
+		case len(parts) == 2 && parts[1] == "docs":
+		case len(parts) == 3 && parts[1] == "docs" && parts[2] != "":
+			rt.key = parts[2]
+		case len(parts) == 2 && parts[1] == "query":
+			rt.query = true
+		default:
+			return rt, errNotFound
+		}
+		rt.set, rt.hasDocs = parts[0], !rt.query
+	}
+	if rt.ref == "" || rt.set == "" && (rt.hasDocs || rt.query) {
+		return rt, errNotFound
+	}
+	return rt, nil
+}
+
+// ifMatch returns the ref in the If-Match header, or an empty ref if absent
+func ifMatch(req *http.Request) (isodb.BlobRef, error) {
+	val := strings.Trim(req.Header.Get("If-Match"), `"`)
+	if val == "" {
+		return isodb.BlobRef{}, nil
+	}
+	ref, err := isodb.ParseBlobRef(val)
+	if err != nil {
+		return isodb.BlobRef{}, badRequest(err)
+	}
+	return ref, nil
+}
+
+func etag(ref isodb.BlobRef) string {
+	return `"` + ref.String() + `"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeErr writes err with the status code matching its cause, errors returned by
+// the repository might be wrapped with more context
+func writeErr(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch cause := errors.Cause(err).(type) {
+	case httpErr:
+		status = cause.status
+	case isodb.ErrInvalidQuery, isodb.ErrInvalidDefinition:
+		status = http.StatusBadRequest
+	case isodb.ErrValidation:
+		status = http.StatusUnprocessableEntity
+	case isodb.ErrPointerMismatch:
+		status = http.StatusPreconditionFailed
+	case isodb.ErrMergeConflict:
+		status = http.StatusConflict
+	case isodb.ErrVetoed:
+		status = http.StatusForbidden
+	default:
+		switch cause {
+		case isodb.ErrDocumentNotFound, isodb.ErrPointerNotFound, isodb.ErrKeyNotFound:
+			status = http.StatusNotFound
+		case isodb.ErrInvalidOldRef:
+			status = http.StatusPreconditionFailed
+		case isodb.ErrInvalidPointerName, isodb.ErrReservedSet, isodb.ErrEmptyPointerRef:
+			status = http.StatusBadRequest
+		}
+	}
+	writeJSON(w, status, errBody{Error: err.Error()})
+}
+
+var (
+	errNotFound = httpErr{status: http.StatusNotFound, msg: "httpapi: not found"}
+	errMethod   = httpErr{status: http.StatusMethodNotAllowed, msg: "httpapi: method not allowed"}
+)
+
+func badRequest(err error) error {
+	return httpErr{status: http.StatusBadRequest, msg: err.Error()}
+}
+
+func (e httpErr) Error() string {
+	return e.msg
+}
@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/isodb"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	kv, err := isodb.NewTempKV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(NewHandler(isodb.NewRepoWithKV(kv)))
+}
+
+func do(t *testing.T, method, url, ifMatch, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestHandler(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	res := do(t, http.MethodPost, srv.URL+"/refs/heads/main/sets/people/docs", "", `{"name":"bob"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Unexpected status %v", res.Status)
+	}
+	var doc docBody
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	first := res.Header.Get("ETag")
+
+	docURL := srv.URL + "/refs/heads/main/sets/people/docs/" + doc.Key
+	res = do(t, http.MethodGet, docURL, "", "")
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != first {
+		t.Fatalf("Unexpected response %v %v", res.Status, res.Header)
+	}
+
+	res = do(t, http.MethodPut, docURL, first, `{"name":"Bob"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %v", res.Status)
+	}
+	second := res.Header.Get("ETag")
+
+	res = do(t, http.MethodPut, docURL, first, `{"name":"bob"}`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("Stale If-Match should fail, got %v", res.Status)
+	}
+
+	res = do(t, http.MethodGet, srv.URL+"/commits/"+strings.Trim(first, `"`)+"/sets/people/docs", "", "")
+	var docs []docBody
+	if err := json.NewDecoder(res.Body).Decode(&docs); err != nil {
+		t.Fatal(err)
+	} else if len(docs) != 1 || docs[0].Key != doc.Key {
+		t.Fatalf("Unexpected list %v", docs)
+	}
+	res.Body.Close()
+
+	res = do(t, http.MethodDelete, docURL, second, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %v", res.Status)
+	}
+	res = do(t, http.MethodGet, docURL, "", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("Document should have been removed, got %v", res.Status)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	for _, doc := range []string{`{"name":"bob","age":30}`, `{"name":"alice","age":25}`} {
+		res := do(t, http.MethodPost, srv.URL+"/refs/heads/main/sets/people/docs", "", doc)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("Unexpected status %v", res.Status)
+		}
+		res.Body.Close()
+	}
+
+	res := do(t, http.MethodPost, srv.URL+"/refs/heads/main/sets/people/query", "",
+		`{"filter": [{"field": "/age", "op": "lt", "value": 30}], "fields": ["/name"]}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %v", res.Status)
+	}
+	var results []isodb.QueryResult
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(results) != 1 || string(results[0].Doc) != `{"name":"alice"}` {
+		t.Fatalf("Unexpected results %v", results)
+	}
+
+	commitURL := srv.URL + "/commits/" + strings.Trim(res.Header.Get("ETag"), `"`) + "/sets/people/query"
+	res = do(t, http.MethodPost, commitURL, "", `{"filter": [{"field": "/age", "op": "between"}]}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Invalid queries should be rejected, got %v", res.Status)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	kv, err := isodb.NewTempKV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := isodb.NewRepoWithKV(kv)
+	repo.AddHooks(isodb.Hooks{
+		PreApply: func(cs *isodb.Changeset) error {
+			for _, k := range cs.Keys() {
+				if k.Set == "locked" {
+					return errors.New("locked")
+				}
+			}
+			return nil
+		},
+	})
+	srv := httptest.NewServer(NewHandler(repo))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		set, body string
+		status    int
+	}{
+		{set: "$x", body: `{}`, status: http.StatusBadRequest},
+		{set: "_indexes", body: `not an index`, status: http.StatusBadRequest},
+		{set: "locked", body: `{}`, status: http.StatusForbidden},
+	} {
+		res := do(t, http.MethodPost, srv.URL+"/refs/heads/main/sets/"+tc.set+"/docs", "", tc.body)
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Fatalf("Writing to %v should fail with %v got %v", tc.set, tc.status, res.Status)
+		}
+	}
+
+	res := do(t, http.MethodPost, srv.URL+"/refs/heads/main/sets/people/docs", "", `{}`)
+	res.Body.Close()
+	head := res.Header.Get("ETag")
+	res = do(t, http.MethodPut, srv.URL+"/refs/heads/main", head, `{}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Updating a pointer without ref should fail got %v", res.Status)
+	}
+	res = do(t, http.MethodGet, srv.URL+"/refs/heads/main", "", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != head {
+		t.Fatalf("The pointer should not change, got %v %v", res.Status, res.Header.Get("ETag"))
+	}
+}
@@ -0,0 +1,70 @@
+package isodb
+
+import (
+	"strings"
+	"sync"
+)
+
+type (
+	// pointerHub delivers PointerEvents to the subscribers in this process
+	pointerHub struct {
+		mu   sync.Mutex
+		subs map[*pointerSub]struct{}
+	}
+
+	pointerSub struct {
+		prefix string
+		ch     chan PointerEvent
+	}
+)
+
+const (
+	// events buffered per subscriber, events are dropped when the buffer is full
+	pointerSubBuffer = 64
+)
+
+func newPointerHub() *pointerHub {
+	return &pointerHub{subs: make(map[*pointerSub]struct{})}
+}
+
+func newPointerSub(prefix string) *pointerSub {
+	return &pointerSub{prefix: prefix, ch: make(chan PointerEvent, pointerSubBuffer)}
+}
+
+// subscribe starts delivering events to sub, until unsubscribe is called
+func (h *pointerHub) subscribe(sub *pointerSub) {
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+}
+
+// unsubscribe stops delivering events to sub, once it returns no more events are sent
+func (h *pointerHub) unsubscribe(sub *pointerSub) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+func (h *pointerHub) publish(events []PointerEvent) {
+	if len(events) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		for _, ev := range events {
+			sub.send(ev)
+		}
+	}
+}
+
+// send ev if it matches the prefix of sub, without blocking
+func (sub *pointerSub) send(ev PointerEvent) {
+	if !strings.HasPrefix(ev.Name, sub.prefix) {
+		return
+	}
+	select {
+	case sub.ch <- ev:
+	default:
+	}
+}
@@ -0,0 +1,230 @@
+package isodb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	// Record is the representation of a document used by Import and Export.
+	//
+	// JSON documents are kept as-is in Content, any other document is stored in ContentBase64
+	Record struct {
+		Set           string          `json:"set"`
+		Key           string          `json:"ksuid,omitempty"`
+		Content       json.RawMessage `json:"content,omitempty"`
+		ContentBase64 []byte          `json:"content_base64,omitempty"`
+	}
+
+	// ImportOptions controls how Import writes the records
+	ImportOptions struct {
+		// Pointer advanced after every batch, required
+		Pointer string
+
+		// ID of the import job used to record progress, defaults to Pointer
+		ID string
+
+		// Resume skips the records committed by a previous execution with the same ID
+		Resume bool
+
+		// BatchSize is the maximum number of records per commit, defaults to 1000
+		BatchSize int
+
+		// BatchBytes is the maximum size of the content per commit, defaults to 4MB
+		BatchBytes int
+
+		// Progress is called after every commit
+		Progress func(ImportProgress)
+
+		// Actor recorded in the pointer log
+		Actor string
+	}
+
+	// ImportProgress reports the state of an import
+	ImportProgress struct {
+		// Records committed so far, including the ones skipped by Resume
+		Records int64
+		// Commits created by this execution
+		Commits int
+		// Commit is the last commit created
+		Commit BlobRef
+	}
+
+	importer struct {
+		repo  *Repo
+		opts  ImportOptions
+		state ImportProgress
+		cs    *Changeset
+		bytes int
+	}
+)
+
+const (
+	defaultImportBatchSize  = 1000
+	defaultImportBatchBytes = 4 << 20
+
+	// prefix used to store the progress of imports
+	importPrefix = "imports/"
+
+	// number of times a batch is committed again when the pointer is moved concurrently
+	maxImportAttempts = 10
+)
+
+// Import reads newline-delimited JSON records (see Record) from in and writes them in
+// commits of bounded size chained on opts.Pointer.
+//
+// Records without a key receive a new random key. The number of committed records
+// is saved together with every pointer update, so an interrupted import can continue
+// from the last committed batch by setting opts.Resume.
+func (r *Repo) Import(in io.Reader, opts ImportOptions) (ImportProgress, error) {
+	if err := ValidatePointerName(opts.Pointer); err != nil {
+		return ImportProgress{}, err
+	}
+	if opts.ID == "" {
+		opts.ID = opts.Pointer
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatchSize
+	}
+	if opts.BatchBytes <= 0 {
+		opts.BatchBytes = defaultImportBatchBytes
+	}
+	imp := &importer{repo: r, opts: opts, cs: NewChangeset()}
+	if opts.Resume {
+		val, err := r.kv.Get(importPrefix + opts.ID)
+		if err == nil {
+			err = defaultCodec.decode(&imp.state, val)
+		} else if err == ErrKeyNotFound {
+			err = nil
+		}
+		if err != nil {
+			return ImportProgress{}, err
+		}
+		imp.state.Commits = 0
+	}
+
+	reader := bufio.NewReader(in)
+	var records int64
+	for {
+		buf, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return imp.state, err
+		}
+		if len(bytes.TrimSpace(buf)) > 0 {
+			records++
+			if records > imp.state.Records {
+				if perr := imp.add(buf); perr != nil {
+					return imp.state, errors.Wrapf(perr, "isodb: invalid record %v", records)
+				}
+			}
+		}
+		if imp.full() || (err == io.EOF && len(imp.cs.leafs) > 0) {
+			if err := imp.commit(records); err != nil {
+				return imp.state, err
+			}
+		}
+		if err == io.EOF {
+			return imp.state, nil
+		}
+	}
+}
+
+func (imp *importer) add(line []byte) error {
+	var rec Record
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return err
+	}
+	key, content, err := rec.document()
+	if err != nil {
+		return err
+	}
+	imp.cs.Put(key, content)
+	imp.bytes += len(content.Content)
+	return nil
+}
+
+func (imp *importer) full() bool {
+	return len(imp.cs.leafs) >= imp.opts.BatchSize || imp.bytes >= imp.opts.BatchBytes
+}
+
+// commit the current batch on top of the pointer, retrying with an exponential backoff
+// if the pointer is moved concurrently. ErrInvalidOldRef is returned after maxImportAttempts
+func (imp *importer) commit(records int64) error {
+	staged := imp.cs
+	backoff := minUpdateBackoff
+	for attempt := 1; ; attempt++ {
+		head, err := imp.repo.GetPointer(imp.opts.Pointer)
+		if err != nil && err != ErrPointerNotFound {
+			return err
+		}
+		cs := NewChangeset()
+		if !head.IsZero() {
+			cs = NewChangeset(head)
+		}
+		cs.leafs = staged.leafs
+		commit, err := imp.repo.Apply(cs)
+		if err != nil {
+			return err
+		}
+		state := ImportProgress{Records: records, Commits: imp.state.Commits + 1, Commit: commit}
+		encoded, err := defaultCodec.encode(state)
+		if err != nil {
+			return err
+		}
+		err = imp.repo.pointerTx(func(ptx *pointerTx) error {
+			err := ptx.update(PointerUpdate{
+				Name:   imp.opts.Pointer,
+				Old:    head,
+				New:    commit,
+				Actor:  imp.opts.Actor,
+				Reason: "import " + imp.opts.ID,
+			})
+			if err != nil {
+				return err
+			}
+			return ptx.tx.Put(importPrefix+imp.opts.ID, encoded)
+		})
+		if err == ErrInvalidOldRef && attempt < maxImportAttempts {
+			time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+			if backoff *= 2; backoff > maxUpdateBackoff {
+				backoff = maxUpdateBackoff
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		imp.state = state
+		imp.cs, imp.bytes = NewChangeset(), 0
+		if imp.opts.Progress != nil {
+			imp.opts.Progress(state)
+		}
+		return nil
+	}
+}
+
+// document returns the key and content described by the record
+func (rec Record) document() (DocumentKey, Blob, error) {
+	if rec.Set == "" {
+		return DocumentKey{}, Blob{}, errors.New("isodb: record without set")
+	}
+	key := NewRandomKey(rec.Set)
+	if rec.Key != "" {
+		k, err := ksuid.Parse(rec.Key)
+		if err != nil {
+			return DocumentKey{}, Blob{}, err
+		}
+		key.K = k
+	}
+	content := Blob{Content: []byte(rec.Content)}
+	if rec.ContentBase64 != nil {
+		content.Content = rec.ContentBase64
+	}
+	return key, content, nil
+}
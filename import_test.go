@@ -0,0 +1,90 @@
+package isodb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+func TestImport(t *testing.T) {
+	repo := newRepo(t)
+	bob := ksuid.New()
+	input := `{"set": "people", "ksuid": "` + bob.String() + `", "content": {"name": "bob"}}
+{"set": "people", "content": {"name": "alice"}}
+
+{"set": "files", "content_base64": "AAEC"}
+`
+	var calls int
+	progress, err := repo.Import(strings.NewReader(input), ImportOptions{
+		Pointer:   "heads/main",
+		BatchSize: 2,
+		Progress:  func(ImportProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if progress.Records != 3 || progress.Commits != 2 || calls != 2 {
+		t.Fatalf("Unexpected progress %#v (%v calls)", progress, calls)
+	}
+
+	head, err := repo.GetPointer("heads/main")
+	if err != nil {
+		t.Fatal(err)
+	} else if head != progress.Commit {
+		t.Fatalf("Pointer should be at %v got %v", progress.Commit, head)
+	}
+	if content, err := repo.GetContentAtKey(head, DocumentKey{Set: "people", K: bob}); err != nil {
+		t.Fatal(err)
+	} else if string(content.Content) != `{"name": "bob"}` {
+		t.Fatalf("Content differs. Got %v", string(content.Content))
+	}
+	if keys, err := repo.List(head, "files"); err != nil {
+		t.Fatal(err)
+	} else if content, err := repo.GetContentAtKey(head, keys[0]); err != nil {
+		t.Fatal(err)
+	} else if string(content.Content) != "\x00\x01\x02" {
+		t.Fatalf("Content differs. Got %v", content.Content)
+	}
+
+	input += `{"set": "people", "content": {"name": "carol"}}` + "\n"
+	progress, err = repo.Import(strings.NewReader(input), ImportOptions{
+		Pointer: "heads/main",
+		Resume:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if progress.Records != 4 || progress.Commits != 1 {
+		t.Fatalf("Unexpected progress %#v", progress)
+	}
+	if keys, err := repo.List(progress.Commit, "people"); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 3 {
+		t.Fatalf("Resume should skip committed records, got %v", keys)
+	}
+
+	_, err = repo.Import(strings.NewReader(`{"content": {}}`), ImportOptions{Pointer: "heads/main"})
+	if err == nil {
+		t.Fatal("Records without set should be rejected")
+	}
+}
+
+func TestImportContention(t *testing.T) {
+	repo := newRepo(t)
+	attempts := 0
+	// every commit created by the import is preceded by a concurrent one
+	repo.AddHooks(Hooks{
+		PostApply: func(commit BlobRef) {
+			attempts++
+			head, _ := repo.GetPointer("heads/busy")
+			if err := repo.UpdatePointer("heads/busy", commit, head); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	_, err := repo.Import(strings.NewReader(`{"set": "people", "content": {}}`), ImportOptions{Pointer: "heads/busy"})
+	if err != ErrInvalidOldRef {
+		t.Fatalf("Import should give up with %v got %v", ErrInvalidOldRef, err)
+	} else if attempts != maxImportAttempts {
+		t.Fatalf("Import should try %v times got %v", maxImportAttempts, attempts)
+	}
+}
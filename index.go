@@ -0,0 +1,309 @@
+package isodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	// Index declares that documents in Set should be indexed by the value found
+	// at Pointer (a JSON pointer, RFC 6901) inside their content.
+	//
+	// Indexes are defined by adding documents to IndexesSet, Apply keeps the entries
+	// of every index in the commit tree, so they are versioned together with the data.
+	// Documents which are not JSON or do not have a value at Pointer are not indexed.
+	Index struct {
+		Name    string `json:"name"`
+		Set     string `json:"set"`
+		Pointer string `json:"pointer"`
+	}
+
+	// indexer computes the edits required to keep the indexes of a tree up to date
+	indexer struct {
+		repo  *Repo
+		root  BlobRef
+		cs    *Changeset
+		blobs blobMap
+		edits []treeEdit
+	}
+)
+
+const (
+	// IndexesSet contains the Index definitions of the repository
+	IndexesSet = "_indexes"
+
+	// ErrIndexNotFound indicates that the index is not defined in the commit
+	ErrIndexNotFound = strErr("isodb: index not found")
+
+	// ErrReservedSet indicates that the set name cannot be used by documents
+	ErrReservedSet = strErr("isodb: set name is reserved")
+
+	// root entries starting with reservedPrefix are not documents
+	reservedPrefix = "$"
+
+	// root entry holding the index trees, organized as
+	// $indexes/<name>/<hash[:2]>/<hash>/<ksuid> where hash is the sha256 of the
+	// canonical JSON value
+	indexesRoot = reservedPrefix + "indexes"
+)
+
+// reservedName returns true if the root entry name is not a set of documents
+func reservedName(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix)
+}
+
+// systemSet returns true if the documents of set configure the Repo instead of holding data
+func systemSet(set string) bool {
+	switch set {
+	case IndexesSet, ResolversSet, ValidatorsSet, SchemasSet:
+		return true
+	}
+	return false
+}
+
+// ToBlob encodes the index definition to be stored in IndexesSet
+func (idx Index) ToBlob() Blob {
+	blob, err := json.Marshal(idx)
+	if err != nil {
+		panic("this should never ever happen! " + err.Error())
+	}
+	return Blob{Content: blob}
+}
+
+func (idx Index) validate() error {
+	if idx.Name == "" || idx.Name == "." || idx.Name == ".." || strings.Contains(idx.Name, "/") {
+		return errors.Errorf("isodb: invalid index name %q", idx.Name)
+	}
+	if idx.Set == "" || reservedName(idx.Set) {
+		return errors.Errorf("isodb: invalid set %q for index %v", idx.Set, idx.Name)
+	}
+	return validJSONPointer(idx.Pointer)
+}
+
+// Lookup returns the keys of the documents indexed by index with the given value,
+// sorted by K.
+//
+// value is compared using its JSON representation, so 1 and 1.0 match the same documents.
+func (r *Repo) Lookup(commit BlobRef, index string, value interface{}) ([]DocumentKey, error) {
+	root, err := r.commitRoot(commit)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := r.indexes(root)
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	for _, i := range indexes {
+		if i.Name == index {
+			idx = i
+		}
+	}
+	if idx.Name == "" {
+		return nil, ErrIndexNotFound
+	}
+	return r.lookupIndex(root, idx, value)
+}
+
+// lookupIndex returns the keys of the documents with value in idx, sorted by K
+func (r *Repo) lookupIndex(root BlobRef, idx Index, value interface{}) ([]DocumentKey, error) {
+	hash, err := hashJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.loadFile(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range []string{indexesRoot, idx.Name, hash[:2], hash} {
+		e, i := file.Children.FindByName(p)
+		if i.NotFound() {
+			return nil, nil
+		}
+		if file, err = r.loadFile(e.Ref); err != nil {
+			return nil, err
+		}
+	}
+	keys := make([]DocumentKey, 0, len(file.Children))
+	for _, e := range file.Children {
+		k, err := ksuid.Parse(e.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "isodb: invalid entry in index %v", idx.Name)
+		}
+		keys = append(keys, DocumentKey{Set: idx.Set, K: k})
+	}
+	return keys, nil
+}
+
+// indexes returns the index definitions found in the tree starting at root
+func (r *Repo) indexes(root BlobRef) (map[DocumentKey]Index, error) {
+	indexes := make(map[DocumentKey]Index)
+	err := walkSet(r, root, IndexesSet, func(k DocumentKey, content BlobRef) error {
+		b, err := r.GetBlob(content)
+		if err != nil {
+			return err
+		}
+		idx, err := decodeIndex(b)
+		if err != nil {
+			return err
+		}
+		indexes[k] = idx
+		return nil
+	})
+	return indexes, err
+}
+
+func decodeIndex(b Blob) (Index, error) {
+	var idx Index
+	if err := json.Unmarshal(b.Content, &idx); err != nil {
+		return Index{}, errors.Wrap(err, "isodb: invalid index definition")
+	}
+	return idx, idx.validate()
+}
+
+// indexEdits returns the edits which update the indexes of the tree at root
+// after cs is applied to it.
+//
+// Indexes which are new or changed by cs are rebuilt from scratch, the others are
+// updated only for the documents changed by cs.
+func (r *Repo) indexEdits(root BlobRef, cs *Changeset, blobs blobMap) ([]treeEdit, error) {
+	before, err := r.indexes(root)
+	if err != nil {
+		return nil, err
+	}
+	after := make(map[DocumentKey]Index, len(before))
+	for k, idx := range before {
+		if !cs.Deleted(k) {
+			after[k] = idx
+		}
+	}
+	for k, b := range cs.leafs {
+		if k.Set != IndexesSet {
+			continue
+		}
+		idx, err := decodeIndex(b)
+		if err != nil {
+			return nil, invalidDefinition(k, err)
+		}
+		after[k] = idx
+	}
+
+	oldByName := make(map[string]Index, len(before))
+	for _, idx := range before {
+		oldByName[idx.Name] = idx
+	}
+	newByName := make(map[string]Index, len(after))
+	for k, idx := range after {
+		if _, dup := newByName[idx.Name]; dup {
+			return nil, ErrInvalidDefinition{Key: k, Reason: fmt.Sprintf("index %v defined more than once", idx.Name)}
+		}
+		newByName[idx.Name] = idx
+	}
+
+	ix := &indexer{repo: r, root: root, cs: cs, blobs: blobs}
+	for name, old := range oldByName {
+		if idx, ok := newByName[name]; !ok || idx != old {
+			ix.edits = append(ix.edits, treeEdit{path: []string{indexesRoot, name}, remove: true})
+		}
+	}
+	for name, idx := range newByName {
+		if old, ok := oldByName[name]; ok && old == idx {
+			err = ix.update(idx)
+		} else {
+			err = ix.rebuild(idx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ix.edits, nil
+}
+
+// rebuild adds the entries for every document in the set of idx
+func (ix *indexer) rebuild(idx Index) error {
+	err := walkSet(ix.repo, ix.root, idx.Set, func(k DocumentKey, content BlobRef) error {
+		if _, changed := ix.cs.leafs[k]; changed || ix.cs.Deleted(k) {
+			return nil
+		}
+		b, err := ix.repo.GetBlob(content)
+		if err != nil {
+			return err
+		}
+		return ix.entry(idx, k, b, content, false)
+	})
+	if err != nil {
+		return err
+	}
+	for k, b := range ix.cs.leafs {
+		if k.Set == idx.Set {
+			if err := ix.entry(idx, k, b, ix.blobs.put(b), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// update replaces the entries of the documents changed by the changeset
+func (ix *indexer) update(idx Index) error {
+	removeOld := func(k DocumentKey) error {
+		old, err := findContent(ix.repo, ix.root, k)
+		if err == ErrDocumentNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		b, err := ix.repo.GetBlob(old)
+		if err != nil {
+			return err
+		}
+		return ix.entry(idx, k, b, old, true)
+	}
+	for k, b := range ix.cs.leafs {
+		if k.Set != idx.Set {
+			continue
+		}
+		// the old entry is removed before adding the new one, if both have the
+		// same path the last edit wins
+		if err := removeOld(k); err != nil {
+			return err
+		}
+		if err := ix.entry(idx, k, b, ix.blobs.put(b), false); err != nil {
+			return err
+		}
+	}
+	for k := range ix.cs.removed {
+		if k.Set == idx.Set {
+			if err := removeOld(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entry adds (or removes) the index entry for the document with content b
+func (ix *indexer) entry(idx Index, k DocumentKey, b Blob, content BlobRef, remove bool) error {
+	doc, err := decodeJSON(b)
+	if err != nil {
+		return nil
+	}
+	value, ok := lookupJSONPointer(doc, idx.Pointer)
+	if !ok {
+		return nil
+	}
+	hash, err := hashJSON(value)
+	if err != nil {
+		return err
+	}
+	ix.edits = append(ix.edits, treeEdit{
+		path:    []string{indexesRoot, idx.Name, hash[:2], hash, k.K.String()},
+		content: content,
+		remove:  remove,
+	})
+	return nil
+}
@@ -0,0 +1,105 @@
+package isodb
+
+import (
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	repo := newRepo(t)
+	bob, alice := NewRandomKey("people"), NewRandomKey("people")
+	byEmail := NewRandomKey(IndexesSet)
+
+	cs := NewChangeset()
+	cs.Put(byEmail, Index{Name: "by-email", Set: "people", Pointer: "/email"}.ToBlob())
+	cs.Put(bob, NewBlobString(`{"email": "bob@example.com", "age": 30}`))
+	cs.Put(alice, NewBlobString(`{"email": "alice@example.com", "age": 30.0}`))
+	cs.Put(NewRandomKey("people"), NewBlobString("not json"))
+	c1, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(commit BlobRef, index string, value interface{}, expected ...DocumentKey) {
+		t.Helper()
+		keys, err := repo.Lookup(commit, index, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != len(expected) {
+			t.Fatalf("Lookup %v=%v should return %v got %v", index, value, expected, keys)
+		}
+		found := make(map[DocumentKey]bool)
+		for _, k := range keys {
+			found[k] = true
+		}
+		for _, k := range expected {
+			if !found[k] {
+				t.Fatalf("Lookup %v=%v should return %v got %v", index, value, expected, keys)
+			}
+		}
+	}
+	lookup(c1, "by-email", "bob@example.com", bob)
+	lookup(c1, "by-email", "carol@example.com")
+	if _, err := repo.Lookup(c1, "by-age", 30); err != ErrIndexNotFound {
+		t.Fatalf("Lookup on missing index should fail with ErrIndexNotFound got %v", err)
+	}
+
+	cs = NewChangeset(c1)
+	cs.Put(bob, NewBlobString(`{"email": "robert@example.com", "age": 30}`))
+	cs.Put(NewRandomKey(IndexesSet), Index{Name: "by-age", Set: "people", Pointer: "/age"}.ToBlob())
+	c2, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(c2, "by-email", "bob@example.com")
+	lookup(c2, "by-email", "robert@example.com", bob)
+	lookup(c2, "by-age", 30, bob, alice)
+	lookup(c1, "by-email", "bob@example.com", bob)
+
+	if changes, err := repo.Diff(c1, c2); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 2 {
+		t.Fatalf("Diff should not include index entries: %v", changes)
+	}
+
+	cs = NewChangeset(c2)
+	cs.Delete(alice)
+	cs.Put(byEmail, Index{Name: "by-email", Set: "people", Pointer: "/age"}.ToBlob())
+	c3, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(c3, "by-age", 30, bob)
+	lookup(c3, "by-email", "robert@example.com")
+	lookup(c3, "by-email", 30, bob)
+
+	cs = NewChangeset(c3)
+	cs.Delete(byEmail)
+	c4, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Lookup(c4, "by-email", 30); err != ErrIndexNotFound {
+		t.Fatalf("Removed indexes should not be found got %v", err)
+	}
+
+	cs = NewChangeset(c4)
+	cs.Put(NewRandomKey(IndexesSet), Index{Name: "by-age", Set: "people", Pointer: "/email"}.ToBlob())
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Duplicated index names should be rejected")
+	}
+	cs = NewChangeset(c4)
+	cs.Put(NewRandomKey(indexesRoot), NewBlobString("{}"))
+	if _, err := repo.Apply(cs); err != ErrReservedSet {
+		t.Fatalf("Reserved sets should be rejected got %v", err)
+	}
+
+	if err := repo.UpdatePointer(HeadPointer("main"), c3, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := repo.Fsck(); err != nil {
+		t.Fatal(err)
+	} else if !report.OK() {
+		t.Fatalf("Index trees should be valid: %v", report.Problems)
+	}
+}
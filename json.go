@@ -0,0 +1,156 @@
+package isodb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// decodeJSON decodes the content of b as a generic JSON value
+func decodeJSON(b Blob) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b.Content))
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// genericJSON converts v to the values produced by decoding JSON into an interface{}
+func genericJSON(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	return generic, json.Unmarshal(buf, &generic)
+}
+
+// canonicalJSON encodes v so equal values produce the same output regardless
+// of how they were written (key order, number formatting)
+func canonicalJSON(v interface{}) ([]byte, error) {
+	generic, err := genericJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+// hashJSON returns the hex encoded sha256 of the canonical encoding of v
+func hashJSON(v interface{}) (string, error) {
+	buf, err := canonicalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// validJSONPointer returns an error if ptr is not a valid JSON pointer (RFC 6901)
+func validJSONPointer(ptr string) error {
+	if ptr != "" && !strings.HasPrefix(ptr, "/") {
+		return errors.Errorf("isodb: invalid json pointer %q", ptr)
+	}
+	return nil
+}
+
+// lookupJSONPointer returns the value at ptr inside doc, false if there is no such value
+func lookupJSONPointer(doc interface{}, ptr string) (interface{}, bool) {
+	if ptr == "" {
+		return doc, true
+	} else if !strings.HasPrefix(ptr, "/") {
+		return nil, false
+	}
+	for _, token := range strings.Split(ptr[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = v[token]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// setJSONPointer sets the value at ptr inside doc, creating the objects along the path
+func setJSONPointer(doc map[string]interface{}, ptr string, v interface{}) {
+	tokens := strings.Split(strings.TrimPrefix(ptr, "/"), "/")
+	for i, token := range tokens {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		if i == len(tokens)-1 {
+			doc[token] = v
+			return
+		}
+		next, ok := doc[token].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[token] = next
+		}
+		doc = next
+	}
+}
+
+// compareJSON orders generic JSON values, values of different types are ordered as
+// null < bool < number < string < array < object. Arrays and objects are compared
+// by their canonical encoding
+func compareJSON(a, b interface{}) int {
+	if ra, rb := jsonRank(a), jsonRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case float64:
+		switch b := b.(float64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	ca, _ := canonicalJSON(a)
+	cb, _ := canonicalJSON(b)
+	return bytes.Compare(ca, cb)
+}
+
+func jsonRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	}
+	return 5
+}
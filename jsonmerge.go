@@ -0,0 +1,130 @@
+package isodb
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// JSONConflict is a field changed differently by both sides of MergeJSON
+	JSONConflict struct {
+		// Pointer to the field (RFC 6901), empty for the whole document
+		Pointer string
+		// Values of the field on each side, nil if the field does not exist
+		Base   json.RawMessage
+		Ours   json.RawMessage
+		Theirs json.RawMessage
+	}
+
+	// missingJSON marks a field (or document) which does not exist
+	missingJSON struct{}
+)
+
+// MergeJSON merges the changes made by ours and theirs to the JSON document base.
+//
+// Objects are merged key by key recursively, any other value (including arrays) is
+// replaced as a whole. A field changed by only one side takes that change, a field
+// changed in the same way by both sides is kept and a field changed differently
+// is reported as a JSONConflict. An empty Blob means the document does not exist,
+// so removals are merged like any other change.
+//
+// If there are conflicts the returned Blob should be ignored.
+func MergeJSON(base, ours, theirs Blob) (Blob, []JSONConflict, error) {
+	var values [3]interface{}
+	for i, b := range []Blob{base, ours, theirs} {
+		if len(b.Content) == 0 {
+			values[i] = missingJSON{}
+			continue
+		}
+		var err error
+		if values[i], err = decodeJSON(b); err != nil {
+			return Blob{}, nil, errors.Wrap(err, "isodb: document is not JSON")
+		}
+	}
+	var conflicts []JSONConflict
+	merged := mergeJSONValue(values[0], values[1], values[2], "", &conflicts)
+	if len(conflicts) > 0 {
+		return Blob{}, conflicts, nil
+	}
+	if _, removed := merged.(missingJSON); removed {
+		return Blob{}, nil, nil
+	}
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+	return Blob{Content: content}, nil, nil
+}
+
+// mergeJSONValue returns the merged value at ptr, missingJSON if it should be removed
+func mergeJSONValue(base, ours, theirs interface{}, ptr string, conflicts *[]JSONConflict) interface{} {
+	switch {
+	case equalJSON(ours, theirs), equalJSON(base, theirs):
+		return ours
+	case equalJSON(base, ours):
+		return theirs
+	}
+	oursObj, oursOk := ours.(map[string]interface{})
+	theirsObj, theirsOk := theirs.(map[string]interface{})
+	baseObj, baseOk := base.(map[string]interface{})
+	if _, missing := base.(missingJSON); missing {
+		baseObj, baseOk = nil, true
+	}
+	if !oursOk || !theirsOk || !baseOk {
+		*conflicts = append(*conflicts, JSONConflict{
+			Pointer: ptr,
+			Base:    rawJSON(base),
+			Ours:    rawJSON(ours),
+			Theirs:  rawJSON(theirs),
+		})
+		return ours
+	}
+
+	keys := make(map[string]bool, len(oursObj)+len(theirsObj))
+	for _, obj := range []map[string]interface{}{baseObj, oursObj, theirsObj} {
+		for k := range obj {
+			keys[k] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	merged := make(map[string]interface{}, len(keys))
+	field := func(obj map[string]interface{}, k string) interface{} {
+		if v, ok := obj[k]; ok {
+			return v
+		}
+		return missingJSON{}
+	}
+	for _, k := range sorted {
+		v := mergeJSONValue(field(baseObj, k), field(oursObj, k), field(theirsObj, k), ptr+"/"+escapeJSONPointer(k), conflicts)
+		if _, removed := v.(missingJSON); !removed {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// equalJSON compares generic JSON values which might be missing
+func equalJSON(a, b interface{}) bool {
+	_, aMissing := a.(missingJSON)
+	_, bMissing := b.(missingJSON)
+	if aMissing || bMissing {
+		return aMissing == bMissing
+	}
+	return compareJSON(a, b) == 0
+}
+
+// rawJSON encodes v, returns nil if v is missing
+func rawJSON(v interface{}) json.RawMessage {
+	if _, missing := v.(missingJSON); missing {
+		return nil
+	}
+	raw, _ := json.Marshal(v)
+	return raw
+}
@@ -0,0 +1,50 @@
+package isodb
+
+import (
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	for _, tc := range []struct {
+		base, ours, theirs string
+		merged             string
+		conflicts          []string
+	}{
+		{base: `{"a": 1, "b": 2}`, ours: `{"a": 10, "b": 2}`, theirs: `{"a": 1, "b": 20}`, merged: `{"a":10,"b":20}`},
+		{base: `{"a": 1}`, ours: `{"a": 1, "b": 2}`, theirs: `{}`, merged: `{"b":2}`},
+		{base: `{"a": 1}`, ours: `{"a": 2}`, theirs: `{"a": 2}`, merged: `{"a":2}`},
+		{base: `{"a": {"x": 1, "y": 1}}`, ours: `{"a": {"x": 2, "y": 1}}`, theirs: `{"a": {"x": 1, "y": 2, "z": 3}}`, merged: `{"a":{"x":2,"y":2,"z":3}}`},
+		{base: `{"l": [1, 2]}`, ours: `{"l": [1, 2, 3]}`, theirs: `{"l": [1, 2], "n": null}`, merged: `{"l":[1,2,3],"n":null}`},
+		{base: ``, ours: `{"a": 1}`, theirs: `{"b": 2}`, merged: `{"a":1,"b":2}`},
+		{base: `{"a": 1}`, ours: ``, theirs: `{"a": 1}`, merged: ``},
+		{base: `{"a": 1, "b": 1}`, ours: `{"a": 2, "b": 2}`, theirs: `{"a": 3, "b": 2}`, conflicts: []string{"/a"}},
+		{base: `{"a": {"x/y": 1}}`, ours: `{"a": {"x/y": 2}}`, theirs: `{"a": {}}`, conflicts: []string{"/a/x~1y"}},
+		{base: `{"l": [1]}`, ours: `{"l": [1, 2]}`, theirs: `{"l": [0, 1]}`, conflicts: []string{"/l"}},
+		{base: `{"a": 1}`, ours: `{"a": 1, "b": 1}`, theirs: ``, conflicts: []string{""}},
+		{base: `"text"`, ours: `{"a": 1}`, theirs: `{"b": 1}`, conflicts: []string{""}},
+	} {
+		merged, conflicts, err := MergeJSON(NewBlobString(tc.base), NewBlobString(tc.ours), NewBlobString(tc.theirs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != len(tc.conflicts) {
+			t.Fatalf("Merge of %v %v %v should report %v got %v", tc.base, tc.ours, tc.theirs, tc.conflicts, conflicts)
+		}
+		for i, c := range conflicts {
+			if c.Pointer != tc.conflicts[i] {
+				t.Fatalf("Merge of %v %v %v should report %v got %v", tc.base, tc.ours, tc.theirs, tc.conflicts, conflicts)
+			}
+		}
+		if len(conflicts) == 0 && string(merged.Content) != tc.merged {
+			t.Fatalf("Merge of %v %v %v should be %v got %s", tc.base, tc.ours, tc.theirs, tc.merged, merged.Content)
+		}
+	}
+
+	_, conflicts, _ := MergeJSON(NewBlobString(`{"a": 1}`), NewBlobString(`{"a": 2}`), NewBlobString(`{}`))
+	if c := conflicts[0]; string(c.Base) != "1" || string(c.Ours) != "2" || c.Theirs != nil {
+		t.Fatalf("Unexpected conflict %+v", c)
+	}
+	if _, _, err := MergeJSON(NewBlobString(`{}`), NewBlobString(`not json`), NewBlobString(`{}`)); err == nil {
+		t.Fatal("MergeJSON should fail with documents which are not JSON")
+	}
+}
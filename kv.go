@@ -2,6 +2,7 @@ package isodb
 
 import (
 	"bytes"
+	"context"
 	"io"
 )
 
@@ -22,11 +23,41 @@ type (
 		// CAS update the key if old value matches the expected old (syntaic sugar for PutIf)
 		CAS(k string, old, new Blob) (bool, error)
 
-		// Get returns the value for the given key
+		// Get returns the value for the given key or ErrKeyNotFound
 		Get(k string) (Blob, error)
 
 		// Has returns if the key is present in the database
 		Has(k string) (bool, error)
+
+		// DeleteIf removes k if the current value passes the given check function (next is always empty)
+		DeleteIf(k string, check CheckFn) (bool, error)
+
+		// Keys returns all keys starting with prefix in lexicographical order
+		Keys(prefix string) ([]string, error)
+
+		// Update executes fn inside a transaction, changes are only visible if fn returns nil
+		Update(fn func(tx KVTx) error) error
+	}
+
+	// KVTx is used to read/write multiple keys atomically
+	KVTx interface {
+		// Get returns the value for the given key or ErrKeyNotFound
+		Get(k string) (Blob, error)
+
+		// Put the given key in the database
+		Put(k string, b Blob) error
+
+		// Delete the given key from the database
+		Delete(k string) error
+	}
+
+	// WatchKV is implemented by KVs which can notify writes made through any handle
+	// to the underlying store
+	WatchKV interface {
+		// Watch calls fn for every key starting with prefix written after ready is called,
+		// blocks until ctx is done. ready is called once, unless Watch fails before the
+		// subscription is active
+		Watch(ctx context.Context, prefix string, ready func(), fn func(k string, b Blob)) error
 	}
 
 	// CheckFn is by PutIf
@@ -36,6 +67,9 @@ type (
 const (
 	// ErrCASNotExecuted indicates that a KV CAS operation didn't work
 	ErrCASNotExecuted = strErr("isodb: unable to perform CAS operation")
+
+	// ErrKeyNotFound indicates that the key is not present in the KV
+	ErrKeyNotFound = strErr("isodb: key not found")
 )
 
 func alwaysTrue(_, _ Blob) (bool, error) { return true, nil }
@@ -44,6 +78,6 @@ func onlyIfMissing(old, _ Blob) (bool, error) {
 }
 func cas(old Blob) CheckFn {
 	return func(prev, next Blob) (bool, error) {
-		return bytes.Equal(prev.Content, next.Content), nil
+		return bytes.Equal(prev.Content, old.Content), nil
 	}
 }
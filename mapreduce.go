@@ -0,0 +1,431 @@
+package isodb
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// MapFunc returns the rows emitted by a document for a view, it must only depend
+	// on its inputs since results are reused between commits
+	MapFunc func(k DocumentKey, b Blob) ([]ViewKV, error)
+
+	// ReduceFunc combines all values emitted with the same key
+	ReduceFunc func(key string, values []Blob) (Blob, error)
+
+	// ViewKV is a row emitted by a MapFunc
+	ViewKV struct {
+		Key   string
+		Value Blob
+	}
+
+	// View is a map/reduce function registered with Repo.RegisterView.
+	//
+	// Results are stored per commit and identified by Name and Version, change the
+	// Version whenever Map or Reduce change to discard previous results. Documents of
+	// the system sets (IndexesSet, ResolversSet, ValidatorsSet and SchemasSet) are
+	// not mapped.
+	View struct {
+		Name    string
+		Version string
+		Map     MapFunc
+		// Reduce is optional
+		Reduce ReduceFunc
+	}
+
+	// ViewQuery selects the rows returned by Repo.View
+	ViewQuery struct {
+		// Start is the first key returned (inclusive)
+		Start string
+		// End is the last key (exclusive), empty means no limit
+		End string
+		// Reduce returns the reduced value of each key instead of the map rows
+		Reduce bool
+	}
+
+	// ViewRow is a row returned by Repo.View, Doc is empty for reduced rows
+	ViewRow struct {
+		Key   string
+		Doc   DocumentKey
+		Value Blob
+	}
+
+	// viewBuilder updates the results of a view with the changes between two commits
+	viewBuilder struct {
+		repo  *Repo
+		view  View
+		blobs *inMemBlobMap
+		edits []treeEdit
+		// keys (hex encoded) which must be reduced again
+		touched map[string]string
+	}
+
+	// viewValues is the content of a leaf in the rows tree, one leaf holds
+	// all values emitted by a document for a key
+	viewValues [][]byte
+
+	// overlayLoader reads files from blobs before reading from the repo
+	overlayLoader struct {
+		blobs *inMemBlobMap
+		repo  *Repo
+	}
+)
+
+const (
+	// ErrViewNotFound indicates that the view was not registered
+	ErrViewNotFound = strErr("isodb: view not found")
+
+	// prefix used to store the results of views, as views/<name>@<version>/<commit>
+	viewPrefix = "views/"
+
+	// the results of a view are stored in a tree with two children, rows and reduce.
+	// Keys are hex encoded (which keeps their order) and organized as
+	// rows/<hex[:2]>/<hex[:4]>/<hex>/<set>/<ksuid> and reduce/<hex[:2]>/<hex[:4]>/<hex>
+	viewRows   = "rows"
+	viewReduce = "reduce"
+)
+
+// RegisterView makes the view available to Repo.View, registering a view with
+// the same name replaces the previous one.
+func (r *Repo) RegisterView(v View) error {
+	if v.Name == "" || strings.ContainsAny(v.Name, "/@") {
+		return errors.Errorf("isodb: invalid view name %q", v.Name)
+	}
+	if v.Map == nil {
+		return errors.Errorf("isodb: view %v without map function", v.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.views == nil {
+		r.views = make(map[string]View)
+	}
+	r.views[v.Name] = v
+	return nil
+}
+
+// View returns the rows of the view at commit with keys in the range selected by q,
+// sorted by key.
+//
+// Results are computed once per commit and stored in the KV. When a commit is queried
+// for the first time, the results of its closest ancestor (following first parents)
+// are updated with the documents which changed between them, so querying a pointer
+// as it advances only maps the documents changed since the previous query.
+//
+// Results are only computed by View, advancing a pointer or creating a commit does not
+// run the Map functions.
+func (r *Repo) View(commit BlobRef, name string, q ViewQuery) ([]ViewRow, error) {
+	r.mu.Lock()
+	v, ok := r.views[name]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrViewNotFound
+	}
+	if q.Reduce && v.Reduce == nil {
+		return nil, errors.Errorf("isodb: view %v does not have a reduce function", name)
+	}
+	root, err := r.materializeView(v, commit)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.loadFile(root)
+	if err != nil {
+		return nil, err
+	}
+	top := viewRows
+	if q.Reduce {
+		top = viewReduce
+	}
+	e, i := file.Children.FindByName(top)
+	if i.NotFound() {
+		return nil, nil
+	}
+	var rows []ViewRow
+	start, end := hex.EncodeToString([]byte(q.Start)), hex.EncodeToString([]byte(q.End))
+	err = r.walkViewRange(e.Ref, 0, start, end, func(hx string, leaf File) error {
+		key, err := hex.DecodeString(hx)
+		if err != nil {
+			return err
+		}
+		if q.Reduce {
+			b, err := r.GetBlob(leaf.GetFileContent())
+			if err != nil {
+				return err
+			}
+			rows = append(rows, ViewRow{Key: string(key), Value: b})
+			return nil
+		}
+		for _, d := range leaf.Children {
+			values, err := overlayLoader{repo: r}.values(d.Ref)
+			if err != nil {
+				return err
+			}
+			doc, err := keyFromPaths(strings.Split(d.Name, "/"))
+			if err != nil {
+				return err
+			}
+			for _, val := range values {
+				rows = append(rows, ViewRow{Key: string(key), Doc: doc, Value: Blob{Content: val}})
+			}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// walkViewRange calls fn with the folder of every key in the range [start, end)
+func (r *Repo) walkViewRange(ref BlobRef, depth int, start, end string, fn func(hx string, f File) error) error {
+	file, err := r.loadFile(ref)
+	if err != nil {
+		return err
+	}
+	for _, e := range file.Children {
+		name := e.Name
+		if depth < 2 {
+			// buckets hold every key starting with name
+			if name < prefix(start, len(name)) || (end != "" && name > prefix(end, len(name))) {
+				continue
+			}
+		} else if name < start || (end != "" && name >= end) {
+			continue
+		}
+		if depth < 2 {
+			err = r.walkViewRange(e.Ref, depth+1, start, end, fn)
+		} else {
+			var leaf File
+			if leaf, err = r.loadFile(e.Ref); err == nil {
+				err = fn(name, leaf)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// materializeView returns the root of the results of v at commit
+func (r *Repo) materializeView(v View, commit BlobRef) (BlobRef, error) {
+	if commit.IsZero() {
+		return BlobRef{}, nil
+	}
+	stored := func(c BlobRef) (BlobRef, bool, error) {
+		val, err := r.kv.Get(viewKey(v, c))
+		if err == ErrKeyNotFound {
+			return BlobRef{}, false, nil
+		} else if err != nil {
+			return BlobRef{}, false, err
+		}
+		var root BlobRef
+		return root, true, defaultCodec.decode(&root, val)
+	}
+	var base, baseRoot BlobRef
+	for c := commit; !c.IsZero(); {
+		root, ok, err := stored(c)
+		if err != nil {
+			return BlobRef{}, err
+		} else if ok {
+			base, baseRoot = c, root
+			break
+		}
+		parent, err := r.GetCommit(c)
+		if err != nil {
+			return BlobRef{}, err
+		}
+		c = BlobRef{}
+		if len(parent.Parents) > 0 {
+			c = parent.Parents[0]
+		}
+	}
+	if base == commit {
+		return baseRoot, nil
+	}
+
+	alg, err := r.HashAlg()
+	if err != nil {
+		return BlobRef{}, err
+	}
+	vb := &viewBuilder{repo: r, view: v, blobs: &inMemBlobMap{alg: alg}, touched: make(map[string]string)}
+	fromRoot, err := r.commitRoot(base)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	toRoot, err := r.commitRoot(commit)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	if err := diffFiles(r, fromRoot, toRoot, nil, vb.change); err != nil {
+		return BlobRef{}, err
+	}
+	root, err := vb.build(baseRoot)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	if err := r.persistBlobs(vb.blobs); err != nil {
+		return BlobRef{}, err
+	}
+	return root, r.kv.Put(viewKey(v, commit), root.ToBlob())
+}
+
+// change replaces the rows emitted by the old version of the document with
+// the rows of the new version
+func (vb *viewBuilder) change(c DocumentChange) error {
+	if systemSet(c.Key.Set) {
+		return nil
+	}
+	// removals are added first, so the new rows win if they use the same path
+	if !c.Old.IsZero() {
+		rows, err := vb.mapDocument(c.Key, c.Old)
+		if err != nil {
+			return err
+		}
+		for hx := range rows {
+			vb.edits = append(vb.edits, treeEdit{path: viewPath(viewRows, hx, c.Key), remove: true})
+		}
+	}
+	if !c.New.IsZero() {
+		rows, err := vb.mapDocument(c.Key, c.New)
+		if err != nil {
+			return err
+		}
+		for hx, values := range rows {
+			content := vb.blobs.put(values)
+			vb.edits = append(vb.edits, treeEdit{path: viewPath(viewRows, hx, c.Key), content: content})
+		}
+	}
+	return nil
+}
+
+// mapDocument returns the values emitted by the document grouped by the hex encoded key
+func (vb *viewBuilder) mapDocument(k DocumentKey, content BlobRef) (map[string]viewValues, error) {
+	b, err := vb.repo.GetBlob(content)
+	if err != nil {
+		return nil, err
+	}
+	emitted, err := vb.view.Map(k, b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "isodb: view %v failed on %v", vb.view.Name, k)
+	}
+	rows := make(map[string]viewValues)
+	for _, kv := range emitted {
+		if kv.Key == "" {
+			return nil, errors.Errorf("isodb: view %v emitted an empty key for %v", vb.view.Name, k)
+		}
+		hx := hex.EncodeToString([]byte(kv.Key))
+		rows[hx] = append(rows[hx], kv.Value.Content)
+		vb.touched[hx] = kv.Key
+	}
+	return rows, nil
+}
+
+// build applies the edits to the results at root and reduces the touched keys
+func (vb *viewBuilder) build(root BlobRef) (BlobRef, error) {
+	tb := &treeBuilder{files: overlayLoader{blobs: vb.blobs, repo: vb.repo}, blobs: vb.blobs}
+	root, err := tb.build(root, vb.edits)
+	if err != nil || vb.view.Reduce == nil || len(vb.touched) == 0 {
+		return root, err
+	}
+	var edits []treeEdit
+	for hx, key := range vb.touched {
+		values, err := vb.values(root, hx)
+		if err != nil {
+			return BlobRef{}, err
+		}
+		path := viewPath(viewReduce, hx, DocumentKey{})
+		if len(values) == 0 {
+			edits = append(edits, treeEdit{path: path, remove: true})
+			continue
+		}
+		reduced, err := vb.view.Reduce(key, values)
+		if err != nil {
+			return BlobRef{}, errors.Wrapf(err, "isodb: view %v failed to reduce %q", vb.view.Name, key)
+		}
+		edits = append(edits, treeEdit{path: path, content: vb.blobs.put(reduced)})
+	}
+	return tb.build(root, edits)
+}
+
+// values returns every value emitted for the key in the results at root
+func (vb *viewBuilder) values(root BlobRef, hx string) ([]Blob, error) {
+	l := overlayLoader{blobs: vb.blobs, repo: vb.repo}
+	file, err := l.loadFile(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range viewPath(viewRows, hx, DocumentKey{}) {
+		e, i := file.Children.FindByName(p)
+		if i.NotFound() {
+			return nil, nil
+		}
+		if file, err = l.loadFile(e.Ref); err != nil {
+			return nil, err
+		}
+	}
+	var values []Blob
+	for _, d := range file.Children {
+		docValues, err := l.values(d.Ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range docValues {
+			values = append(values, Blob{Content: v})
+		}
+	}
+	return values, nil
+}
+
+// ToBlob implements toBlober
+func (v viewValues) ToBlob() Blob {
+	blob, err := defaultCodec.encode(v)
+	if err != nil {
+		panic("this should never ever happen! " + err.Error())
+	}
+	return blob
+}
+
+func (l overlayLoader) loadFile(ref BlobRef) (File, error) {
+	if l.blobs != nil && l.blobs.has(ref) {
+		var f File
+		return f, f.FromBlob(l.blobs.raw(nil, ref))
+	}
+	return l.repo.loadFile(ref)
+}
+
+// values decodes the values stored in the leaf at ref
+func (l overlayLoader) values(ref BlobRef) (viewValues, error) {
+	leaf, err := l.loadFile(ref)
+	if err != nil {
+		return nil, err
+	}
+	content := leaf.GetFileContent()
+	var b Blob
+	if l.blobs != nil && l.blobs.has(content) {
+		b = l.blobs.raw(nil, content)
+	} else if b, err = l.repo.GetBlob(content); err != nil {
+		return nil, err
+	}
+	var values viewValues
+	return values, defaultCodec.decode(&values, b)
+}
+
+// viewPath returns the path of the rows of doc with the hex encoded key, an empty doc
+// returns the path of the folder holding every row of the key
+func viewPath(top, hx string, doc DocumentKey) []string {
+	p := []string{top, prefix(hx, 2), prefix(hx, 4), hx}
+	if doc.Set != "" {
+		p = append(p, doc.String())
+	}
+	return p
+}
+
+func viewKey(v View, commit BlobRef) string {
+	return viewPrefix + v.Name + "@" + v.Version + "/" + commit.String()
+}
+
+func prefix(str string, n int) string {
+	if len(str) < n {
+		return str
+	}
+	return str[:n]
+}
@@ -0,0 +1,121 @@
+package isodb
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	repo := newRepo(t)
+	var mapped int
+	err := repo.RegisterView(View{
+		Name: "by-tag",
+		Map: func(k DocumentKey, b Blob) ([]ViewKV, error) {
+			mapped++
+			var doc struct{ Tags []string }
+			if err := json.Unmarshal(b.Content, &doc); err != nil {
+				return nil, nil
+			}
+			var rows []ViewKV
+			for _, t := range doc.Tags {
+				rows = append(rows, ViewKV{Key: t, Value: NewBlobString(k.K.String())})
+			}
+			return rows, nil
+		},
+		Reduce: func(key string, values []Blob) (Blob, error) {
+			return NewBlobString(strconv.Itoa(len(values))), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, c := NewRandomKey("posts"), NewRandomKey("posts"), NewRandomKey("posts")
+	cs := NewChangeset()
+	cs.Put(a, NewBlobString(`{"tags": ["go", "db"]}`))
+	cs.Put(b, NewBlobString(`{"tags": ["go"]}`))
+	cs.Put(c, NewBlobString(`{"tags": ["rust"]}`))
+	c1, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reduced := func(commit BlobRef, q ViewQuery) map[string]string {
+		t.Helper()
+		q.Reduce = true
+		rows, err := repo.View(commit, "by-tag", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]string)
+		for i, r := range rows {
+			if i > 0 && rows[i-1].Key >= r.Key {
+				t.Fatalf("Rows should be sorted by key: %v", rows)
+			}
+			out[r.Key] = string(r.Value.Content)
+		}
+		return out
+	}
+
+	if counts := reduced(c1, ViewQuery{}); len(counts) != 3 || counts["go"] != "2" || counts["db"] != "1" {
+		t.Fatalf("Unexpected reduce: %v", counts)
+	}
+	if counts := reduced(c1, ViewQuery{Start: "e", End: "s"}); len(counts) != 2 || counts["go"] != "2" || counts["rust"] != "1" {
+		t.Fatalf("Unexpected range: %v", counts)
+	}
+	rows, err := repo.View(c1, "by-tag", ViewQuery{Start: "go", End: "go\x00"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 2 || rows[0].Doc.Set != "posts" {
+		t.Fatalf("Unexpected rows: %v", rows)
+	}
+	for _, r := range rows {
+		if r.Doc.K.String() != string(r.Value.Content) {
+			t.Fatalf("Rows should include the document: %v", r)
+		}
+	}
+
+	cs = NewChangeset(c1)
+	cs.Put(b, NewBlobString(`{"tags": ["db"]}`))
+	cs.Delete(c)
+	c2, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped = 0
+	if counts := reduced(c2, ViewQuery{}); len(counts) != 2 || counts["go"] != "1" || counts["db"] != "2" {
+		t.Fatalf("Unexpected reduce after update: %v", counts)
+	}
+	if mapped != 3 {
+		t.Fatalf("Only the changed documents should be mapped, got %v calls", mapped)
+	}
+	mapped = 0
+	if counts := reduced(c1, ViewQuery{}); counts["go"] != "2" || mapped != 0 {
+		t.Fatalf("Results should be kept per commit: %v", counts)
+	}
+
+	// views are computed by View, not when commits are created, and ignore system sets
+	cs = NewChangeset(c2)
+	cs.Put(NewRandomKey(ResolversSet), Resolver{Set: "posts", Script: `ours`}.ToBlob())
+	cs.Put(c, NewBlobString(`{"tags": ["rust"]}`))
+	mapped = 0
+	c3, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	} else if mapped != 0 {
+		t.Fatalf("Apply should not map documents, got %v calls", mapped)
+	}
+	if has, err := repo.kv.Has(viewKey(repo.views["by-tag"], c3)); err != nil || has {
+		t.Fatalf("Results should not be stored before View is called, got %v %v", has, err)
+	}
+	if counts := reduced(c3, ViewQuery{}); len(counts) != 3 || counts["rust"] != "1" {
+		t.Fatalf("Unexpected reduce after update: %v", counts)
+	} else if mapped != 1 {
+		t.Fatalf("Documents of system sets should not be mapped, got %v calls", mapped)
+	}
+
+	if _, err := repo.View(c1, "missing", ViewQuery{}); err != ErrViewNotFound {
+		t.Fatalf("Expecting ErrViewNotFound got %v", err)
+	}
+}
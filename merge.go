@@ -0,0 +1,307 @@
+package isodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/andrebq/isodb/script"
+)
+
+type (
+	// Resolver is a script (see package script) which resolves the conflicts found by
+	// Merge for the documents of Set.
+	//
+	// Resolvers are stored as documents in ResolversSet, so they are synced together with
+	// the data. The script is executed with the variables base, ours and theirs holding
+	// the decoded JSON content of each version (nil if the document does not exist, a string
+	// if it is not JSON) and key with the document key as Set/K. The result is encoded as
+	// JSON and written as the merged document, a nil result removes the document.
+	//
+	// Scripts should treat ours and theirs in the same way, so both sides of a merge
+	// end up with the same commit.
+	Resolver struct {
+		// Set handled by the resolver, empty handles every set without a resolver
+		Set    string `json:"set"`
+		Script string `json:"script"`
+	}
+
+	// MergeConflict describes a document changed differently by both sides of a merge
+	MergeConflict struct {
+		Key    DocumentKey
+		Base   BlobRef
+		Ours   BlobRef
+		Theirs BlobRef
+		// Reason why the conflict could not be resolved
+		Reason string
+		// Fields changed differently by both sides, when the document was merged with MergeJSON
+		Fields []JSONConflict
+	}
+
+	// ErrMergeConflict is returned by Merge when some conflicts could not be resolved
+	ErrMergeConflict struct {
+		Conflicts []MergeConflict
+	}
+
+	merger struct {
+		repo      *Repo
+		cs        *Changeset
+		resolvers map[string]*script.Program
+	}
+)
+
+const (
+	// ResolversSet contains the Resolver definitions of the repository
+	ResolversSet = "_resolvers"
+
+	// MaxResolverSteps limits the number of steps of a single resolver execution
+	MaxResolverSteps = 1000000
+)
+
+// ToBlob encodes the resolver to be stored in ResolversSet
+func (res Resolver) ToBlob() Blob {
+	blob, err := json.Marshal(res)
+	if err != nil {
+		panic("this should never ever happen! " + err.Error())
+	}
+	return Blob{Content: blob}
+}
+
+// Merge combines the changes made by the commits ours and theirs since their MergeBase
+// and returns the resulting commit.
+//
+// If one commit already contains the other no new commit is created, otherwise the
+// merge commit has both as parents. Documents changed differently by both sides are
+// resolved by the Resolver of their set, using the resolvers found after merging
+// ResolversSet itself. Documents without a resolver are merged field by field with
+// MergeJSON. If any conflict cannot be resolved, ErrMergeConflict is returned.
+func (r *Repo) Merge(ours, theirs BlobRef) (BlobRef, error) {
+	base, err := r.MergeBase(ours, theirs)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	switch {
+	case ours == theirs || base == theirs:
+		return ours, nil
+	case base == ours:
+		return theirs, nil
+	}
+
+	oursChanges, err := r.Diff(base, ours)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	theirsChanges, err := r.Diff(base, theirs)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	changed := make(map[DocumentKey]DocumentChange, len(oursChanges))
+	for _, c := range oursChanges {
+		changed[c.Key] = c
+	}
+
+	m := &merger{repo: r, cs: NewChangeset(ours, theirs)}
+	m.cs.ours = ours
+	var conflicts []MergeConflict
+	for _, c := range theirsChanges {
+		o, both := changed[c.Key]
+		switch {
+		case !both:
+			if err := m.take(c.Key, c.New); err != nil {
+				return BlobRef{}, err
+			}
+		case o.New != c.New:
+			conflicts = append(conflicts, MergeConflict{Key: c.Key, Base: c.Old, Ours: o.New, Theirs: c.New})
+		}
+	}
+
+	if err := m.loadResolvers(ours); err != nil {
+		return BlobRef{}, err
+	}
+	var unresolved ErrMergeConflict
+	for _, c := range conflicts {
+		if c.Reason, c.Fields = m.resolve(c); c.Reason != "" {
+			unresolved.Conflicts = append(unresolved.Conflicts, c)
+		}
+	}
+	if len(unresolved.Conflicts) > 0 {
+		return BlobRef{}, unresolved
+	}
+	return r.Apply(m.cs)
+}
+
+// MergeBase returns a common ancestor of a and b, or an empty ref if they do not share
+// any history. A commit is considered an ancestor of itself.
+func (r *Repo) MergeBase(a, b BlobRef) (BlobRef, error) {
+	if a.IsZero() || b.IsZero() {
+		return BlobRef{}, nil
+	}
+	ancestors := make(map[BlobRef]bool)
+	err := r.walkAncestors(a, func(c BlobRef) bool {
+		ancestors[c] = true
+		return true
+	})
+	if err != nil {
+		return BlobRef{}, err
+	}
+	var base BlobRef
+	err = r.walkAncestors(b, func(c BlobRef) bool {
+		if ancestors[c] {
+			base = c
+			return false
+		}
+		return true
+	})
+	return base, err
+}
+
+// walkAncestors visits commit and its ancestors in breadth-first order until fn returns false
+func (r *Repo) walkAncestors(commit BlobRef, fn func(BlobRef) bool) error {
+	visited := map[BlobRef]bool{commit: true}
+	queue := []BlobRef{commit}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if !fn(c) {
+			return nil
+		}
+		parsed, err := r.GetCommit(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range parsed.Parents {
+			if !visited[p] {
+				visited[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return nil
+}
+
+// take copies the content to the merge, an empty content removes the document
+func (m *merger) take(k DocumentKey, content BlobRef) error {
+	if content.IsZero() {
+		m.cs.Delete(k)
+		return nil
+	}
+	b, err := m.repo.GetBlob(content)
+	if err != nil {
+		return err
+	}
+	m.cs.Put(k, b)
+	return nil
+}
+
+// loadResolvers parses the resolvers of ours updated with the changes taken from theirs
+func (m *merger) loadResolvers(ours BlobRef) error {
+	root, err := m.repo.commitRoot(ours)
+	if err != nil {
+		return err
+	}
+	docs, err := m.repo.setDocs(root, ResolversSet, m.cs)
+	if err != nil {
+		return err
+	}
+	m.resolvers = make(map[string]*script.Program)
+	defined := make(map[string]DocumentKey)
+	for _, k := range sortedKeys(docs) {
+		var res Resolver
+		if err := json.Unmarshal(docs[k].Content, &res); err != nil {
+			return invalidDefinition(k, err)
+		}
+		if other, dup := defined[res.Set]; dup {
+			return ErrInvalidDefinition{Key: k, Reason: fmt.Sprintf("set %q is already handled by %v", res.Set, other)}
+		}
+		prog, err := script.Parse(res.Script)
+		if err != nil {
+			return invalidDefinition(k, err)
+		}
+		defined[res.Set], m.resolvers[res.Set] = k, prog
+	}
+	return nil
+}
+
+// resolve the conflict using the resolver of the set, or MergeJSON if there is none.
+// Returns the reason (and the conflicting fields) if the conflict could not be resolved
+func (m *merger) resolve(c MergeConflict) (string, []JSONConflict) {
+	prog, ok := m.resolvers[c.Key.Set]
+	if !ok {
+		prog, ok = m.resolvers[""]
+	}
+	if !ok || c.Key.Set == ResolversSet {
+		return m.mergeFields(c)
+	}
+	vars := map[string]interface{}{"key": c.Key.String()}
+	for name, ref := range map[string]BlobRef{"base": c.Base, "ours": c.Ours, "theirs": c.Theirs} {
+		v, err := m.scriptValue(ref)
+		if err != nil {
+			return err.Error(), nil
+		}
+		vars[name] = v
+	}
+	result, err := prog.Run(vars, MaxResolverSteps)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if result == nil {
+		m.cs.Delete(c.Key)
+		return "", nil
+	}
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err.Error(), nil
+	}
+	m.cs.Put(c.Key, Blob{Content: content})
+	return "", nil
+}
+
+// mergeFields resolves the conflict with MergeJSON
+func (m *merger) mergeFields(c MergeConflict) (string, []JSONConflict) {
+	var versions [3]Blob
+	for i, ref := range []BlobRef{c.Base, c.Ours, c.Theirs} {
+		if ref.IsZero() {
+			continue
+		}
+		var err error
+		if versions[i], err = m.repo.GetBlob(ref); err != nil {
+			return err.Error(), nil
+		}
+	}
+	merged, fields, err := MergeJSON(versions[0], versions[1], versions[2])
+	switch {
+	case err != nil:
+		return err.Error(), nil
+	case len(fields) > 0:
+		pointers := make([]string, 0, len(fields))
+		for _, f := range fields {
+			pointers = append(pointers, "#"+f.Pointer)
+		}
+		return "fields changed on both sides: " + strings.Join(pointers, ", "), fields
+	case len(merged.Content) == 0:
+		m.cs.Delete(c.Key)
+	default:
+		m.cs.Put(c.Key, merged)
+	}
+	return "", nil
+}
+
+// scriptValue returns the content at ref as a script value
+func (m *merger) scriptValue(ref BlobRef) (interface{}, error) {
+	if ref.IsZero() {
+		return nil, nil
+	}
+	b, err := m.repo.GetBlob(ref)
+	if err != nil {
+		return nil, err
+	}
+	return scriptValue(&b), nil
+}
+
+func (e ErrMergeConflict) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%v (%v)", c.Key, c.Reason))
+	}
+	return "isodb: merge conflict: " + strings.Join(conflicts, ", ")
+}
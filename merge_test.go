@@ -0,0 +1,146 @@
+package isodb
+
+import (
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	repo := newRepo(t)
+	apply := func(parent BlobRef, fn func(cs *Changeset)) BlobRef {
+		t.Helper()
+		cs := NewChangeset()
+		if !parent.IsZero() {
+			cs = NewChangeset(parent)
+		}
+		fn(cs)
+		commit, err := repo.Apply(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return commit
+	}
+	content := func(commit BlobRef, k DocumentKey) string {
+		t.Helper()
+		b, err := repo.GetContentAtKey(commit, k)
+		if err == ErrDocumentNotFound {
+			return ""
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(b.Content)
+	}
+
+	counter, note, removed := NewRandomKey("counters"), NewRandomKey("notes"), NewRandomKey("notes")
+	base := apply(BlobRef{}, func(cs *Changeset) {
+		cs.Put(counter, NewBlobString(`{"n": 1}`))
+		cs.Put(note, NewBlobString(`"base"`))
+		cs.Put(removed, NewBlobString(`"removed"`))
+	})
+	ours := apply(base, func(cs *Changeset) {
+		cs.Put(counter, NewBlobString(`{"n": 3}`))
+	})
+	theirsKey := NewRandomKey("notes")
+	theirs := apply(base, func(cs *Changeset) {
+		cs.Put(counter, NewBlobString(`{"n": 2}`))
+		cs.Put(theirsKey, NewBlobString(`"theirs"`))
+		cs.Delete(removed)
+	})
+
+	if mb, err := repo.MergeBase(ours, theirs); err != nil {
+		t.Fatal(err)
+	} else if mb != base {
+		t.Fatalf("MergeBase should be %v got %v", base, mb)
+	}
+	if m, err := repo.Merge(ours, base); err != nil || m != ours {
+		t.Fatalf("Merging an ancestor should return ours, got %v %v", m, err)
+	}
+	if m, err := repo.Merge(base, theirs); err != nil || m != theirs {
+		t.Fatalf("Merging a descendant should fast-forward, got %v %v", m, err)
+	}
+
+	_, err := repo.Merge(ours, theirs)
+	if conflict, ok := err.(ErrMergeConflict); !ok {
+		t.Fatalf("Merge without resolvers should fail with a conflict got %v", err)
+	} else if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Key != counter {
+		t.Fatalf("Unexpected conflicts: %v", conflict)
+	}
+
+	// the resolver for counters is added by theirs, so it is used to merge theirs
+	resolvers := NewRandomKey(ResolversSet)
+	theirs = apply(theirs, func(cs *Changeset) {
+		cs.Put(resolvers, Resolver{Set: "counters", Script: `
+			(define (n doc) (if doc (get doc "n") 0))
+			(put ours "n" (max (n ours) (n theirs)))`}.ToBlob())
+	})
+	merged, err := repo.Merge(ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.GetCommit(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Parents) != 2 || !c.Parents.Contains(ours) || !c.Parents.Contains(theirs) {
+		t.Fatalf("Merge commit should have both parents got %v", c.Parents)
+	}
+	for k, expected := range map[DocumentKey]string{
+		counter:   `{"n":3}`,
+		note:      `"base"`,
+		theirsKey: `"theirs"`,
+		removed:   "",
+	} {
+		if got := content(merged, k); got != expected {
+			t.Fatalf("%v should be %q got %q", k, expected, got)
+		}
+	}
+	if other, err := repo.Merge(theirs, ours); err != nil {
+		t.Fatal(err)
+	} else if other != merged {
+		t.Fatalf("Merging from both sides should produce the same commit, got %v and %v", merged, other)
+	}
+	if m, err := repo.Merge(merged, ours); err != nil || m != merged {
+		t.Fatalf("Merging a parent should return the merge commit, got %v %v", m, err)
+	}
+
+	// failing scripts leave the document as a conflict
+	ours = apply(merged, func(cs *Changeset) {
+		cs.Put(counter, NewBlobString(`{"n": 10}`))
+		cs.Put(resolvers, Resolver{Set: "counters", Script: `(fail "no way")`}.ToBlob())
+	})
+	theirs = apply(merged, func(cs *Changeset) {
+		cs.Put(counter, NewBlobString(`{"n": 11}`))
+	})
+	_, err = repo.Merge(ours, theirs)
+	if conflict, ok := err.(ErrMergeConflict); !ok {
+		t.Fatalf("Failing resolvers should produce a conflict got %v", err)
+	} else if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Reason != "script: no way" {
+		t.Fatalf("Unexpected conflicts: %v", conflict)
+	}
+
+	// documents without a resolver are merged field by field
+	profile := NewRandomKey("profiles")
+	base = apply(merged, func(cs *Changeset) {
+		cs.Put(profile, NewBlobString(`{"name": "bob", "city": "paris", "age": 30}`))
+	})
+	ours = apply(base, func(cs *Changeset) {
+		cs.Put(profile, NewBlobString(`{"name": "bob", "city": "rome", "age": 30}`))
+	})
+	theirs = apply(base, func(cs *Changeset) {
+		cs.Put(profile, NewBlobString(`{"name": "bob", "city": "paris", "age": 31}`))
+	})
+	if merged, err = repo.Merge(ours, theirs); err != nil {
+		t.Fatal(err)
+	}
+	if got := content(merged, profile); got != `{"age":31,"city":"rome","name":"bob"}` {
+		t.Fatalf("Unexpected merged profile %v", got)
+	}
+	theirs = apply(base, func(cs *Changeset) {
+		cs.Put(profile, NewBlobString(`{"name": "bob", "city": "berlin", "age": 31}`))
+	})
+	_, err = repo.Merge(ours, theirs)
+	if conflict, ok := err.(ErrMergeConflict); !ok {
+		t.Fatalf("Merge should fail with a conflict got %v", err)
+	} else if fields := conflict.Conflicts[0].Fields; len(fields) != 1 || fields[0].Pointer != "/city" {
+		t.Fatalf("Unexpected conflicting fields %v", fields)
+	}
+}
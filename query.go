@@ -0,0 +1,327 @@
+package isodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	// Query selects JSON documents from a Set, see Repo.Query.
+	//
+	// Queries can be encoded as JSON, for example:
+	//
+	//	{"filter": [{"field": "/age", "op": "gte", "value": 18}],
+	//	 "fields": ["/name"], "sort": [{"field": "/name"}], "limit": 10}
+	Query struct {
+		// Filter lists the conditions a document must match (all of them)
+		Filter []Filter `json:"filter,omitempty"`
+		// Fields lists the JSON pointers returned for each document, empty returns
+		// the whole document
+		Fields []string `json:"fields,omitempty"`
+		// Sort the results, documents are sorted by key when empty
+		Sort []SortField `json:"sort,omitempty"`
+		// Limit the number of results, zero means no limit
+		Limit int `json:"limit,omitempty"`
+	}
+
+	// Filter compares the value at Field (a JSON pointer) with Value.
+	//
+	// Values are compared as JSON, values of different types are ordered as
+	// null < bool < number < string < array < object
+	Filter struct {
+		Field string   `json:"field"`
+		Op    FilterOp `json:"op"`
+		// Value used by every operator except OpIn, for OpExists false matches
+		// documents without the field
+		Value interface{} `json:"value,omitempty"`
+		// Values used by OpIn
+		Values []interface{} `json:"values,omitempty"`
+	}
+
+	// FilterOp lists the operators supported by Filter
+	FilterOp string
+
+	// SortField sorts by the value at Field (a JSON pointer), missing values come first
+	SortField struct {
+		Field string `json:"field"`
+		Desc  bool   `json:"desc,omitempty"`
+	}
+
+	// QueryResult is a document returned by Query
+	QueryResult struct {
+		Key DocumentKey     `json:"key"`
+		Doc json.RawMessage `json:"doc"`
+	}
+
+	// ErrInvalidQuery indicates that the query cannot be executed
+	ErrInvalidQuery struct {
+		Reason string
+	}
+
+	// queryMatch is a document which matched the filters
+	queryMatch struct {
+		key     DocumentKey
+		doc     interface{}
+		content Blob
+	}
+)
+
+const (
+	// OpEq matches values equal to Value
+	OpEq = FilterOp("eq")
+	// OpIn matches values equal to any of Values
+	OpIn = FilterOp("in")
+	// OpExists matches documents with (or without) the field
+	OpExists = FilterOp("exists")
+	// OpGt matches values greater than Value
+	OpGt = FilterOp("gt")
+	// OpGte matches values greater than or equal to Value
+	OpGte = FilterOp("gte")
+	// OpLt matches values less than Value
+	OpLt = FilterOp("lt")
+	// OpLte matches values less than or equal to Value
+	OpLte = FilterOp("lte")
+
+	// errStopWalk stops the scan once the limit is reached
+	errStopWalk = strErr("isodb:internal: stop walk")
+)
+
+// Query returns the JSON documents of set in commit which match q.
+//
+// If an Index of set uses the same field as an OpEq or OpIn filter, only the documents
+// found in the index are read, otherwise the whole set is scanned. Documents which
+// are not JSON are ignored.
+func (r *Repo) Query(commit BlobRef, set string, q Query) ([]QueryResult, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	root, err := r.commitRoot(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []queryMatch
+	full := func() bool {
+		return len(q.Sort) == 0 && q.Limit > 0 && len(matches) >= q.Limit
+	}
+	visit := func(k DocumentKey, content BlobRef) error {
+		b, err := r.GetBlob(content)
+		if err != nil {
+			return err
+		}
+		doc, err := decodeJSON(b)
+		if err != nil {
+			return nil
+		}
+		for _, f := range q.Filter {
+			if !f.match(doc) {
+				return nil
+			}
+		}
+		matches = append(matches, queryMatch{key: k, doc: doc, content: b})
+		if full() {
+			return errStopWalk
+		}
+		return nil
+	}
+
+	keys, indexed, err := r.queryIndex(root, set, q)
+	if err != nil {
+		return nil, err
+	}
+	if indexed {
+		for _, k := range keys {
+			content, err := findContent(r, root, k)
+			if err != nil {
+				return nil, err
+			}
+			if err := visit(k, content); err == errStopWalk {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	} else if err := walkSet(r, root, set, visit); err != nil && err != errStopWalk {
+		return nil, err
+	}
+
+	if len(q.Sort) > 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, s := range q.Sort {
+				a, _ := lookupJSONPointer(matches[i].doc, s.Field)
+				b, _ := lookupJSONPointer(matches[j].doc, s.Field)
+				c := compareJSON(a, b)
+				if s.Desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	results := make([]QueryResult, 0, len(matches))
+	for _, m := range matches {
+		res := QueryResult{Key: m.key, Doc: json.RawMessage(m.content.Content)}
+		if len(q.Fields) > 0 {
+			projected := make(map[string]interface{})
+			for _, f := range q.Fields {
+				if v, ok := lookupJSONPointer(m.doc, f); ok {
+					setJSONPointer(projected, f, v)
+				}
+			}
+			if res.Doc, err = json.Marshal(projected); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// queryIndex returns the keys of the candidates for q, sorted by K, using an index.
+// Returns false if no index can be used
+func (r *Repo) queryIndex(root BlobRef, set string, q Query) ([]DocumentKey, bool, error) {
+	indexes, err := r.indexes(root)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, f := range q.Filter {
+		if f.Op != OpEq && f.Op != OpIn {
+			continue
+		}
+		for _, idx := range indexes {
+			if idx.Set != set || idx.Pointer != f.Field {
+				continue
+			}
+			values := f.Values
+			if f.Op == OpEq {
+				values = []interface{}{f.Value}
+			}
+			seen := make(map[DocumentKey]bool)
+			var keys []DocumentKey
+			for _, v := range values {
+				found, err := r.lookupIndex(root, idx, v)
+				if err != nil {
+					return nil, false, err
+				}
+				for _, k := range found {
+					if !seen[k] {
+						seen[k] = true
+						keys = append(keys, k)
+					}
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return ksuid.Compare(keys[i].K, keys[j].K) < 0
+			})
+			return keys, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// normalize validates the query and converts the values to their generic JSON form
+func (q *Query) normalize() error {
+	normalize := func(v interface{}) (interface{}, error) {
+		generic, err := genericJSON(v)
+		if err != nil {
+			return nil, ErrInvalidQuery{Reason: err.Error()}
+		}
+		return generic, nil
+	}
+	checkField := func(f string) error {
+		if validJSONPointer(f) != nil {
+			return ErrInvalidQuery{Reason: fmt.Sprintf("invalid field %q", f)}
+		}
+		return nil
+	}
+	// filters are copied so the caller's query is not modified
+	q.Filter = append([]Filter(nil), q.Filter...)
+	for i := range q.Filter {
+		f := &q.Filter[i]
+		if err := checkField(f.Field); err != nil {
+			return err
+		}
+		switch f.Op {
+		case OpIn:
+			values := make([]interface{}, len(f.Values))
+			for j, v := range f.Values {
+				var err error
+				if values[j], err = normalize(v); err != nil {
+					return err
+				}
+			}
+			f.Values = values
+		case OpExists:
+			if _, ok := f.Value.(bool); f.Value != nil && !ok {
+				return ErrInvalidQuery{Reason: fmt.Sprintf("exists on %v requires a boolean", f.Field)}
+			}
+		case OpEq, OpGt, OpGte, OpLt, OpLte:
+			var err error
+			if f.Value, err = normalize(f.Value); err != nil {
+				return err
+			}
+		default:
+			return ErrInvalidQuery{Reason: fmt.Sprintf("unknown operator %q", f.Op)}
+		}
+	}
+	for _, f := range q.Fields {
+		if err := checkField(f); err != nil {
+			return err
+		}
+	}
+	for _, s := range q.Sort {
+		if err := checkField(s.Field); err != nil {
+			return err
+		}
+	}
+	if q.Limit < 0 {
+		return ErrInvalidQuery{Reason: "negative limit"}
+	}
+	return nil
+}
+
+func (f Filter) match(doc interface{}) bool {
+	v, ok := lookupJSONPointer(doc, f.Field)
+	if f.Op == OpExists {
+		if want, isBool := f.Value.(bool); isBool {
+			return ok == want
+		}
+		return ok
+	} else if !ok {
+		return false
+	}
+	switch f.Op {
+	case OpEq:
+		return compareJSON(v, f.Value) == 0
+	case OpIn:
+		for _, candidate := range f.Values {
+			if compareJSON(v, candidate) == 0 {
+				return true
+			}
+		}
+		return false
+	case OpGt:
+		return compareJSON(v, f.Value) > 0
+	case OpGte:
+		return compareJSON(v, f.Value) >= 0
+	case OpLt:
+		return compareJSON(v, f.Value) < 0
+	case OpLte:
+		return compareJSON(v, f.Value) <= 0
+	}
+	return false
+}
+
+func (e ErrInvalidQuery) Error() string {
+	return "isodb: invalid query: " + e.Reason
+}
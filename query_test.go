@@ -0,0 +1,106 @@
+package isodb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	repo := newRepo(t)
+	cs := NewChangeset()
+	people := map[string]DocumentKey{}
+	for _, doc := range []string{
+		`{"name": "bob", "age": 30, "city": "paris"}`,
+		`{"name": "alice", "age": 25, "city": "berlin"}`,
+		`{"name": "carol", "age": 41, "city": "paris", "admin": true}`,
+		`{"name": "dave", "city": "rome"}`,
+	} {
+		var v struct{ Name string }
+		json.Unmarshal([]byte(doc), &v)
+		k := NewRandomKey("people")
+		people[v.Name] = k
+		cs.Put(k, NewBlobString(doc))
+	}
+	cs.Put(NewRandomKey("people"), NewBlobString("not json"))
+	commit, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(q Query) []string {
+		t.Helper()
+		results, err := repo.Query(commit, "people", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, r := range results {
+			var v struct{ Name string }
+			if err := json.Unmarshal(r.Doc, &v); err != nil {
+				t.Fatal(err)
+			}
+			if people[v.Name] != r.Key {
+				t.Fatalf("Unexpected key for %v: %v", v.Name, r.Key)
+			}
+			out = append(out, v.Name)
+		}
+		return out
+	}
+	expect := func(q Query, expected ...string) {
+		t.Helper()
+		got := names(q)
+		if len(got) != len(expected) {
+			t.Fatalf("Query %+v should return %v got %v", q, expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("Query %+v should return %v got %v", q, expected, got)
+			}
+		}
+	}
+
+	byName := []SortField{{Field: "/name"}}
+	expect(Query{Filter: []Filter{{Field: "/city", Op: OpEq, Value: "paris"}}, Sort: byName}, "bob", "carol")
+	expect(Query{Filter: []Filter{{Field: "/age", Op: OpGte, Value: 30}, {Field: "/age", Op: OpLt, Value: 41.5}}, Sort: byName}, "bob", "carol")
+	expect(Query{Filter: []Filter{{Field: "/city", Op: OpIn, Values: []interface{}{"rome", "berlin"}}}, Sort: byName}, "alice", "dave")
+	expect(Query{Filter: []Filter{{Field: "/age", Op: OpExists, Value: false}}}, "dave")
+	expect(Query{Filter: []Filter{{Field: "/admin", Op: OpExists}}}, "carol")
+	expect(Query{Sort: []SortField{{Field: "/age", Desc: true}}, Limit: 2}, "carol", "bob")
+	if got := names(Query{Limit: 3}); len(got) != 3 {
+		t.Fatalf("Limit should be respected: %v", got)
+	}
+
+	results, err := repo.Query(commit, "people", Query{
+		Filter: []Filter{{Field: "/name", Op: OpEq, Value: "carol"}},
+		Fields: []string{"/name", "/age"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(results) != 1 || string(results[0].Doc) != `{"age":41,"name":"carol"}` {
+		t.Fatalf("Projection should only include the fields: %v", results)
+	}
+
+	// results must be the same when an index can be used
+	cs = NewChangeset(commit)
+	cs.Put(NewRandomKey(IndexesSet), Index{Name: "by-city", Set: "people", Pointer: "/city"}.ToBlob())
+	if commit, err = repo.Apply(cs); err != nil {
+		t.Fatal(err)
+	}
+	expect(Query{Filter: []Filter{{Field: "/city", Op: OpEq, Value: "paris"}}, Sort: byName}, "bob", "carol")
+	expect(Query{Filter: []Filter{{Field: "/city", Op: OpIn, Values: []interface{}{"rome", "berlin"}}, {Field: "/age", Op: OpExists}}}, "alice")
+
+	var q Query
+	if err := json.Unmarshal([]byte(`{"filter": [{"field": "/age", "op": "gt", "value": 26}], "sort": [{"field": "/age"}], "limit": 1}`), &q); err != nil {
+		t.Fatal(err)
+	}
+	expect(q, "bob")
+
+	if _, err := repo.Query(commit, "people", Query{Filter: []Filter{{Field: "age", Op: OpEq}}}); err == nil {
+		t.Fatal("Invalid fields should be rejected")
+	} else if _, ok := err.(ErrInvalidQuery); !ok {
+		t.Fatalf("Expecting ErrInvalidQuery got %v", err)
+	}
+	if _, err := repo.Query(commit, "people", Query{Filter: []Filter{{Field: "/age", Op: "like"}}}); err == nil {
+		t.Fatal("Unknown operators should be rejected")
+	}
+}
@@ -0,0 +1,412 @@
+package isodb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Pointer is a human readable name for a BlobRef
+	Pointer struct {
+		Name string
+		Ref  BlobRef
+	}
+
+	// PointerUpdate describes a change to a pointer.
+	//
+	// Old is the value expected before the change (empty if the pointer should not exist),
+	// New is the value after the change (empty removes the pointer).
+	PointerUpdate struct {
+		Name string
+		Old  BlobRef
+		New  BlobRef
+
+		// Actor and Reason are recorded in the pointer log
+		Actor  string
+		Reason string
+	}
+
+	// PointerMismatch describes a pointer which did not have the expected value
+	PointerMismatch struct {
+		Name     string
+		Expected BlobRef
+		Actual   BlobRef
+	}
+
+	// ErrPointerMismatch is returned by UpdatePointers when one or more pointers
+	// did not have the expected value
+	ErrPointerMismatch struct {
+		Mismatches []PointerMismatch
+	}
+
+	// PointerEvent notifies that a pointer changed from Old to New
+	PointerEvent struct {
+		Name string
+		Old  BlobRef
+		New  BlobRef
+	}
+
+	pointerTx struct {
+		repo    *Repo
+		tx      KVTx
+		now     time.Time
+		events  []PointerEvent
+		updates []PointerUpdate
+
+		// updates already accepted by the pre update hooks, so retried
+		// transactions do not call them again
+		accepted map[PointerUpdate]bool
+	}
+
+	// PointerLogEntry records a successful change to a pointer
+	PointerLogEntry struct {
+		// Seq is the position of this entry in the log of the pointer
+		Seq    uint64
+		Old    BlobRef
+		New    BlobRef
+		Time   time.Time
+		Actor  string
+		Reason string
+	}
+)
+
+const (
+	// HeadsNamespace contains pointers to local branches
+	HeadsNamespace = "heads/"
+
+	// TagsNamespace contains pointers to commits which should not move
+	TagsNamespace = "tags/"
+
+	// RemotesNamespace contains the last known values of pointers from other peers,
+	// organized as remotes/<peer>/<name>
+	RemotesNamespace = "remotes/"
+
+	// ErrPointerLogNotFound indicates that the pointer log does not have the requested entry
+	ErrPointerLogNotFound = strErr("isodb: pointer log entry not found")
+
+	// ErrInvalidPointerName indicates that the pointer name is not valid (see ValidatePointerName)
+	ErrInvalidPointerName = strErr("isodb: invalid pointer name")
+
+	// ErrEmptyPointerRef indicates that a pointer was updated to an empty ref,
+	// use DeletePointer to remove pointers
+	ErrEmptyPointerRef = strErr("isodb: empty pointer reference")
+
+	// prefix used to store pointers in the KV
+	pointerPrefix = "refs/"
+
+	// prefix used to store the log of pointers in the KV, entries
+	// are stored as reflog/<name>:<seq> and the last seq as reflog/<name>
+	reflogPrefix = "reflog/"
+)
+
+// HeadPointer returns the name of the local branch
+func HeadPointer(name string) string {
+	return HeadsNamespace + name
+}
+
+// TagPointer returns the name of the tag
+func TagPointer(name string) string {
+	return TagsNamespace + name
+}
+
+// RemotePointer returns the name of the pointer name as known by peer
+func RemotePointer(peer, name string) string {
+	return RemotesNamespace + peer + "/" + name
+}
+
+// ValidatePointerName returns ErrInvalidPointerName if name cannot be used as a pointer.
+//
+// Names are composed by one or more components separated by "/". Components cannot
+// be empty, "." or "..", and cannot contain control characters, spaces or any of ":?*[\\~^".
+// Pointers under RemotesNamespace must include the peer and the name.
+func ValidatePointerName(name string) error {
+	if name == "" {
+		return ErrInvalidPointerName
+	}
+	parts := strings.Split(name, "/")
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			return ErrInvalidPointerName
+		}
+		for _, c := range p {
+			if c <= ' ' || c == 0x7f || strings.ContainsRune(`:?*[\~^`, c) {
+				return ErrInvalidPointerName
+			}
+		}
+	}
+	if parts[0]+"/" == RemotesNamespace && len(parts) < 3 {
+		return ErrInvalidPointerName
+	}
+	return nil
+}
+
+// ListPointers returns all pointers which start with prefix sorted by name.
+func (r *Repo) ListPointers(prefix string) ([]Pointer, error) {
+	keys, err := r.kv.Keys(pointerPrefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	ptrs := make([]Pointer, 0, len(keys))
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, pointerPrefix)
+		ref, err := r.GetPointer(name)
+		if err == ErrPointerNotFound {
+			// removed after the keys were listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ptrs = append(ptrs, Pointer{Name: name, Ref: ref})
+	}
+	return ptrs, nil
+}
+
+// DeletePointer removes ptr if its current value is expectedOld.
+//
+// If the pointer has a different value (or does not exist) ErrInvalidOldRef is returned.
+func (r *Repo) DeletePointer(ptr string, expectedOld BlobRef) error {
+	if expectedOld.IsZero() {
+		return ErrInvalidOldRef
+	}
+	if err := ValidatePointerName(ptr); err != nil {
+		return err
+	}
+	return r.pointerTx(func(ptx *pointerTx) error {
+		return ptx.update(PointerUpdate{Name: ptr, Old: expectedOld})
+	})
+}
+
+// UpdatePointerWith executes the update if the pointer currently has the value u.Old
+// and records it in the pointer log.
+//
+// If the change cannot be executed, then ErrInvalidOldRef is returned. u.New cannot be
+// empty (ErrEmptyPointerRef), use DeletePointer to remove the pointer.
+func (r *Repo) UpdatePointerWith(u PointerUpdate) error {
+	if err := ValidatePointerName(u.Name); err != nil {
+		return err
+	}
+	if u.New.IsZero() {
+		return ErrEmptyPointerRef
+	}
+	return r.pointerTx(func(ptx *pointerTx) error {
+		return ptx.update(u)
+	})
+}
+
+// UpdatePointers executes all updates in a single transaction, either all pointers are
+// updated or none of them is.
+//
+// If any pointer does not have the expected Old value, ErrPointerMismatch is returned
+// listing every pointer which failed the check.
+func (r *Repo) UpdatePointers(updates []PointerUpdate) error {
+	seen := make(map[string]bool, len(updates))
+	for _, u := range updates {
+		if err := ValidatePointerName(u.Name); err != nil {
+			return err
+		}
+		if seen[u.Name] {
+			return errors.Errorf("isodb: pointer %v updated more than once", u.Name)
+		}
+		seen[u.Name] = true
+	}
+	return r.pointerTx(func(ptx *pointerTx) error {
+		var mismatch ErrPointerMismatch
+		for _, u := range updates {
+			current, err := getPointerTx(ptx.tx, u.Name)
+			if err != nil {
+				return err
+			}
+			if current != u.Old {
+				mismatch.Mismatches = append(mismatch.Mismatches, PointerMismatch{
+					Name:     u.Name,
+					Expected: u.Old,
+					Actual:   current,
+				})
+			}
+		}
+		if len(mismatch.Mismatches) > 0 {
+			return mismatch
+		}
+		for _, u := range updates {
+			if err := ptx.update(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PointerLog returns all changes made to ptr, oldest first
+func (r *Repo) PointerLog(ptr string) ([]PointerLogEntry, error) {
+	keys, err := r.kv.Keys(reflogPrefix + ptr + ":")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]PointerLogEntry, 0, len(keys))
+	for _, k := range keys {
+		val, err := r.kv.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		var e PointerLogEntry
+		if err := defaultCodec.decode(&e, val); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ResetPointer moves ptr back to the value it had after the log entry seq.
+//
+// The reset itself is recorded as a new entry in the log.
+func (r *Repo) ResetPointer(ptr string, seq uint64, actor string) error {
+	if err := ValidatePointerName(ptr); err != nil {
+		return err
+	}
+	return r.pointerTx(func(ptx *pointerTx) error {
+		val, err := ptx.tx.Get(reflogKey(ptr, seq))
+		if err == ErrKeyNotFound {
+			return ErrPointerLogNotFound
+		} else if err != nil {
+			return err
+		}
+		var entry PointerLogEntry
+		if err := defaultCodec.decode(&entry, val); err != nil {
+			return err
+		}
+		current, err := getPointerTx(ptx.tx, ptr)
+		if err != nil {
+			return err
+		}
+		return ptx.update(PointerUpdate{
+			Name:   ptr,
+			Old:    current,
+			New:    entry.New,
+			Actor:  actor,
+			Reason: fmt.Sprintf("reset to entry %v", seq),
+		})
+	})
+}
+
+// getPointerTx returns the current value of ptr or an empty ref if it does not exist
+func getPointerTx(tx KVTx, ptr string) (BlobRef, error) {
+	val, err := tx.Get(pointerPrefix + ptr)
+	if err == ErrKeyNotFound {
+		return BlobRef{}, nil
+	} else if err != nil {
+		return BlobRef{}, err
+	}
+	var ref BlobRef
+	return ref, defaultCodec.decode(&ref, val)
+}
+
+// pointerTx runs fn in a KV transaction, the pointer events are published and the
+// post update hooks called once the transaction is committed
+func (r *Repo) pointerTx(fn func(ptx *pointerTx) error) error {
+	ptx := &pointerTx{repo: r, now: time.Now(), accepted: make(map[PointerUpdate]bool)}
+	err := r.kv.Update(func(tx KVTx) error {
+		ptx.tx, ptx.events, ptx.updates = tx, nil, nil
+		return fn(ptx)
+	})
+	if err != nil {
+		return err
+	}
+	r.hub.publish(ptx.events)
+	r.postUpdatePointer(ptx.updates)
+	return nil
+}
+
+// update executes u, after the pre update hooks accept it, and records the event.
+//
+// The hooks are called once per update, even if the transaction is retried
+func (ptx *pointerTx) update(u PointerUpdate) error {
+	if !ptx.accepted[u] {
+		if err := ptx.repo.preUpdatePointer(u); err != nil {
+			return err
+		}
+		ptx.accepted[u] = true
+	}
+	if err := updatePointerTx(ptx.tx, u, ptx.now); err != nil {
+		return err
+	}
+	if u.Old == u.New {
+		return nil
+	}
+	ptx.events = append(ptx.events, PointerEvent{Name: u.Name, Old: u.Old, New: u.New})
+	ptx.updates = append(ptx.updates, u)
+	return nil
+}
+
+func updatePointerTx(tx KVTx, u PointerUpdate, now time.Time) error {
+	current, err := getPointerTx(tx, u.Name)
+	if err != nil {
+		return err
+	}
+	if current != u.Old {
+		return ErrInvalidOldRef
+	} else if u.Old == u.New {
+		// nothing changes, so nothing is logged
+		return nil
+	}
+	if u.New.IsZero() {
+		err = tx.Delete(pointerPrefix + u.Name)
+	} else {
+		err = tx.Put(pointerPrefix+u.Name, u.New.ToBlob())
+	}
+	if err != nil {
+		return err
+	}
+	return appendPointerLogTx(tx, u.Name, PointerLogEntry{
+		Old:    u.Old,
+		New:    u.New,
+		Time:   now,
+		Actor:  u.Actor,
+		Reason: u.Reason,
+	})
+}
+
+func appendPointerLogTx(tx KVTx, ptr string, e PointerLogEntry) error {
+	val, err := tx.Get(reflogPrefix + ptr)
+	if err == nil {
+		err = defaultCodec.decode(&e.Seq, val)
+		e.Seq++
+	} else if err == ErrKeyNotFound {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	seq, err := defaultCodec.encode(e.Seq)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(reflogPrefix+ptr, seq); err != nil {
+		return err
+	}
+	entry, err := defaultCodec.encode(e)
+	if err != nil {
+		return err
+	}
+	return tx.Put(reflogKey(ptr, e.Seq), entry)
+}
+
+func reflogKey(ptr string, seq uint64) string {
+	return fmt.Sprintf("%v%v:%016x", reflogPrefix, ptr, seq)
+}
+
+func (e ErrPointerMismatch) Error() string {
+	names := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		names = append(names, m.Name)
+	}
+	return fmt.Sprintf("%v: %v", ErrInvalidOldRef, strings.Join(names, ", "))
+}
+
+// Unwrap returns ErrInvalidOldRef
+func (e ErrPointerMismatch) Unwrap() error {
+	return ErrInvalidOldRef
+}
@@ -0,0 +1,243 @@
+package isodb
+
+import (
+	"github.com/pkg/errors"
+)
+
+type (
+	// RehashMap maps the refs computed with the old algorithm to the refs computed
+	// with the new one
+	RehashMap map[BlobRef]BlobRef
+
+	rehasher struct {
+		repo    *Repo
+		alg     HashAlg
+		mapping RehashMap
+		blobs   *inMemBlobMap
+	}
+)
+
+const (
+	// prefix used to record the mapping between old and new refs
+	rehashPrefix = "rehash/"
+)
+
+// Rehash rewrites every object reachable from fromRef and from every pointer using toAlg.
+//
+// All pointers are moved to the rewritten commits and the mapping between old and new refs
+// is recorded in the repository, so objects addressed with the previous algorithm can still
+// be translated with TranslateRef (see also ImportCommit). After Rehash returns, new commits
+// use toAlg.
+//
+// The new algorithm and the pointers are changed in a single transaction, so if Rehash fails
+// the repository keeps using the previous algorithm and it can simply be executed again.
+//
+// fromRef can be empty, in which case only the commits reachable from pointers are rewritten.
+func (r *Repo) Rehash(fromRef BlobRef, toAlg HashAlg) (RehashMap, error) {
+	if !toAlg.valid() {
+		return nil, ErrInvalidHashAlgorithm
+	}
+	ptrs, err := r.ListPointers("")
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]BlobRef, len(ptrs))
+	for _, p := range ptrs {
+		targets[p.Name] = p.Ref
+	}
+
+	rh := newRehasher(r, toAlg)
+	if !fromRef.IsZero() {
+		if _, err := rh.commit(fromRef); err != nil {
+			return nil, err
+		}
+	}
+	for _, ref := range targets {
+		if _, err := rh.commit(ref); err != nil {
+			return nil, err
+		}
+	}
+	// objects and mappings are content addressed, writing them more than once is harmless
+	if err := rh.persist(); err != nil {
+		return nil, err
+	}
+	err = r.pointerTx(func(ptx *pointerTx) error {
+		if err := ptx.tx.Put(hashAlgKey, Blob{Content: []byte(toAlg)}); err != nil {
+			return err
+		}
+		for name, ref := range targets {
+			err := ptx.update(PointerUpdate{
+				Name:   name,
+				Old:    ref,
+				New:    rh.mapping[ref],
+				Reason: "rehash to " + string(toAlg),
+			})
+			if err != nil {
+				return errors.Wrapf(err, "isodb: unable to rewrite pointer %v", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rh.mapping, nil
+}
+
+// ImportCommit returns the ref of commit using the algorithm of the repository.
+//
+// Peers which did not run Rehash yet keep sending objects addressed with the previous
+// algorithm. Once those objects are copied with ImportBlob, ImportCommit translates
+// the history which was already migrated and rewrites the rest, so the commit can be
+// merged with the local ones. Commits which already use the current algorithm are
+// returned unchanged.
+func (r *Repo) ImportCommit(commit BlobRef) (BlobRef, error) {
+	alg, err := r.HashAlg()
+	if err != nil {
+		return BlobRef{}, err
+	}
+	rh := newRehasher(r, alg)
+	updated, err := rh.commit(commit)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	return updated, rh.persist()
+}
+
+// TranslateRef returns the ref which replaced old after one or more calls to Rehash.
+//
+// If old was never rewritten, it is returned unchanged.
+func (r *Repo) TranslateRef(old BlobRef) (BlobRef, error) {
+	for {
+		has, err := r.kv.Has(rehashPrefix + old.String())
+		if err != nil {
+			return BlobRef{}, err
+		} else if !has {
+			return old, nil
+		}
+		val, err := r.kv.Get(rehashPrefix + old.String())
+		if err != nil {
+			return BlobRef{}, err
+		}
+		if err := old.FromBlob(val); err != nil {
+			return BlobRef{}, err
+		}
+	}
+}
+
+func newRehasher(r *Repo, alg HashAlg) *rehasher {
+	return &rehasher{
+		repo:    r,
+		alg:     alg,
+		mapping: make(RehashMap),
+		blobs:   &inMemBlobMap{alg: alg},
+	}
+}
+
+// persist writes the rewritten objects and the mapping to the KV
+func (rh *rehasher) persist() error {
+	for _, k := range rh.blobs.keys() {
+		_, err := rh.repo.kv.PutNew(k.String(), rh.blobs.raw(nil, k))
+		if err != nil {
+			return err
+		}
+	}
+	for old, updated := range rh.mapping {
+		if old == updated {
+			continue
+		}
+		err := rh.repo.kv.Put(rehashPrefix+old.String(), updated.ToBlob())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translate returns the ref of the object in the new algorithm, objects which were
+// migrated by a previous Rehash are not rewritten again, the others are rewritten by fn
+func (rh *rehasher) translate(ref BlobRef, fn func(BlobRef) (BlobRef, error)) (BlobRef, error) {
+	if updated, ok := rh.mapping[ref]; ok {
+		return updated, nil
+	}
+	translated, err := rh.repo.TranslateRef(ref)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	updated := translated
+	if translated.Alg != rh.alg {
+		if updated, err = fn(translated); err != nil {
+			return BlobRef{}, err
+		}
+	}
+	rh.mapping[ref] = updated
+	return updated, nil
+}
+
+func (rh *rehasher) commit(ref BlobRef) (BlobRef, error) {
+	return rh.translate(ref, rh.rewriteCommit)
+}
+
+func (rh *rehasher) rewriteCommit(ref BlobRef) (BlobRef, error) {
+	c, err := rh.repo.GetCommit(ref)
+	if err != nil {
+		return BlobRef{}, errors.Wrapf(err, "isodb: unable to read commit %v", ref)
+	}
+	updated := Commit{}
+	updated.Folder, err = rh.file(c.Folder)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	for _, p := range c.Parents {
+		np, err := rh.commit(p)
+		if err != nil {
+			return BlobRef{}, err
+		}
+		updated.Parents = append(updated.Parents, np)
+	}
+	updated.Parents.SortInPlace()
+	return rh.put(ref, &updated), nil
+}
+
+func (rh *rehasher) file(ref BlobRef) (BlobRef, error) {
+	return rh.translate(ref, rh.rewriteFile)
+}
+
+func (rh *rehasher) rewriteFile(ref BlobRef) (BlobRef, error) {
+	f, err := rh.repo.GetFile(ref)
+	if err != nil {
+		return BlobRef{}, errors.Wrapf(err, "isodb: unable to read file %v", ref)
+	}
+	updated := f
+	updated.Children = make(EdgeList, len(f.Children))
+	for i, e := range f.Children {
+		if f.Leaf && e.Name == "blob" {
+			e.Ref, err = rh.content(e.Ref)
+		} else {
+			e.Ref, err = rh.file(e.Ref)
+		}
+		if err != nil {
+			return BlobRef{}, err
+		}
+		updated.Children[i] = e
+	}
+	return rh.put(ref, &updated), nil
+}
+
+func (rh *rehasher) content(ref BlobRef) (BlobRef, error) {
+	return rh.translate(ref, rh.rewriteContent)
+}
+
+func (rh *rehasher) rewriteContent(ref BlobRef) (BlobRef, error) {
+	b, err := rh.repo.GetBlob(ref)
+	if err != nil {
+		return BlobRef{}, errors.Wrapf(err, "isodb: unable to read blob %v", ref)
+	}
+	return rh.put(ref, b), nil
+}
+
+func (rh *rehasher) put(old BlobRef, b toBlober) BlobRef {
+	updated := rh.blobs.put(b)
+	rh.mapping[old] = updated
+	return updated
+}
@@ -0,0 +1,180 @@
+package isodb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRehash(t *testing.T) {
+	repo := newRepo(t)
+	bob := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	ref, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdatePointer("master/HEAD", ref, BlobRef{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping, err := repo.Rehash(ref, Sha512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRef := mapping[ref]
+	if newRef.Alg != Sha512 {
+		t.Fatalf("Commit should use %v got %v", Sha512, newRef)
+	}
+
+	if ptrRef, err := repo.GetPointer("master/HEAD"); err != nil {
+		t.Fatal(err)
+	} else if ptrRef != newRef {
+		t.Fatalf("Pointer should have been rewritten. Expecting %v got %v", newRef, ptrRef)
+	}
+
+	if translated, err := repo.TranslateRef(ref); err != nil {
+		t.Fatal(err)
+	} else if translated != newRef {
+		t.Fatalf("Expecting %v got %v", newRef, translated)
+	}
+
+	content, err := repo.GetContentAtKey(newRef, bob)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(content.Content, NewBlobString("bob bobson").Content) {
+		t.Fatalf("Content differs. Got %v", content.Content)
+	}
+
+	cs = NewChangeset(newRef)
+	cs.Put(bob, NewBlobString("Bob Buffon"))
+	nextRef, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	} else if nextRef.Alg != Sha512 {
+		t.Fatalf("New commits should use %v got %v", Sha512, nextRef)
+	}
+	err = repo.UpdatePointer("master/HEAD", nextRef, newRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCommit(t *testing.T) {
+	repo, peer := newRepo(t), newRepo(t)
+	copyObjects := func() {
+		t.Helper()
+		keys, err := peer.kv.Keys(string(Sha256) + ":")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			ref, err := ParseBlobRef(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := peer.kv.Get(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.ImportBlob(ref, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	bob, alice := NewRandomKey("people"), NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob"))
+	shared, err := peer.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyObjects()
+	if err := repo.UpdatePointer("heads/main", shared, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := repo.Rehash(BlobRef{}, Sha512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer did not migrate and keeps writing sha256 commits
+	cs = NewChangeset(shared)
+	cs.Put(alice, NewBlobString("alice"))
+	next, err := peer.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyObjects()
+	imported, err := repo.ImportCommit(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Alg != Sha512 {
+		t.Fatalf("Imported commit should use %v got %v", Sha512, imported)
+	}
+	c, err := repo.GetCommit(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Parents) != 1 || c.Parents[0] != mapping[shared] {
+		t.Fatalf("Imported commit should have %v as parent got %v", mapping[shared], c.Parents)
+	}
+	if base, err := repo.MergeBase(mapping[shared], imported); err != nil || base != mapping[shared] {
+		t.Fatalf("Imported history should line up with the local one, got %v %v", base, err)
+	}
+	if content, err := repo.GetContentAtKey(imported, alice); err != nil || string(content.Content) != "alice" {
+		t.Fatalf("Unexpected content %q %v", content.Content, err)
+	}
+	if again, err := repo.ImportCommit(next); err != nil || again != imported {
+		t.Fatalf("ImportCommit should reuse the mapping, got %v %v", again, err)
+	}
+	if same, err := repo.ImportCommit(imported); err != nil || same != imported {
+		t.Fatalf("Commits using the current algorithm should not change, got %v %v", same, err)
+	}
+}
+
+func TestRehashAtomic(t *testing.T) {
+	repo := newRepo(t)
+	cs := NewChangeset()
+	cs.Put(NewRandomKey("people"), NewBlobString("bob"))
+	ref, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"heads/main", "heads/other"} {
+		if err := repo.UpdatePointer(name, ref, BlobRef{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fail := true
+	repo.AddHooks(Hooks{PreUpdatePointer: func(u PointerUpdate) error {
+		if fail && u.Name == "heads/other" {
+			return errors.New("failed")
+		}
+		return nil
+	}})
+	if _, err := repo.Rehash(BlobRef{}, Sha512); err == nil {
+		t.Fatal("Rehash should fail")
+	}
+	if alg, err := repo.HashAlg(); err != nil || alg != Sha256 {
+		t.Fatalf("A failed Rehash should keep the algorithm, got %v %v", alg, err)
+	}
+	for _, name := range []string{"heads/main", "heads/other"} {
+		if current, err := repo.GetPointer(name); err != nil || current != ref {
+			t.Fatalf("A failed Rehash should not move %v, got %v %v", name, current, err)
+		}
+	}
+
+	fail = false
+	mapping, err := repo.Rehash(BlobRef{}, Sha512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := repo.GetPointer("heads/other"); err != nil || current != mapping[ref] {
+		t.Fatalf("Pointer should be rewritten to %v got %v %v", mapping[ref], current, err)
+	}
+}
@@ -2,12 +2,29 @@ package isodb
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 )
 
 type (
 	// Repo contains all the commits/changes written to the database
 	Repo struct {
 		kv KV
+
+		// verify the content of every blob read/imported against its ref,
+		// accessed atomically (1 enabled)
+		verify int32
+
+		// decoded File and Commit objects
+		cache *objectCache
+
+		// notifies pointer updates made by this Repo
+		hub *pointerHub
+
+		// protects views and hooks
+		mu    sync.Mutex
+		views map[string]View
+		hooks []Hooks
 	}
 
 	toBlober interface {
@@ -15,7 +32,7 @@ type (
 	}
 
 	blobMap interface {
-		put(b toBlober)
+		put(b toBlober) BlobRef
 		read(out interface{}, r BlobRef) bool
 		raw(out []byte, r BlobRef) Blob
 		has(BlobRef) bool
@@ -29,6 +46,12 @@ const (
 
 	// ErrDocumentNotFound document not found
 	ErrDocumentNotFound = strErr("isodb: document not found")
+
+	// ErrPointerNotFound pointer not found
+	ErrPointerNotFound = strErr("isodb: pointer not found")
+
+	// key holding the HashAlg used for new objects
+	hashAlgKey = "meta/hash-alg"
 )
 
 // NewPersistentRepo returns a new Repo with a persistent stored in `folder`.
@@ -37,12 +60,28 @@ func NewPersistentRepo(folder string) (*Repo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Repo{kv: kv}, nil
+	return NewRepoWithKV(kv), nil
 }
 
 // NewRepoWithKV returns a new Repo using the given KV
 func NewRepoWithKV(kv KV) *Repo {
-	return &Repo{kv: kv}
+	return &Repo{kv: kv, cache: newObjectCache(DefaultCacheSize), hub: newPointerHub()}
+}
+
+// SetCacheSize changes the number of decoded objects (File and Commit) kept in memory,
+// zero disables the cache.
+func (r *Repo) SetCacheSize(size int) {
+	r.cache.resize(size)
+}
+
+// CacheStats returns the usage statistics of the object cache
+func (r *Repo) CacheStats() CacheStats {
+	return r.cache.stats()
+}
+
+// Close the underlying KV
+func (r *Repo) Close() error {
+	return r.kv.Close()
 }
 
 // UpdatePointer ptr from oldRef to newRef, if oldRef is empty then it will only update
@@ -50,27 +89,18 @@ func NewRepoWithKV(kv KV) *Repo {
 //
 // If the change cannot be executed, then ErrInvalidOldRef is returned.
 func (r *Repo) UpdatePointer(ptr string, newRef, oldRef BlobRef) error {
-	ptr = "refs/" + ptr
-	if oldRef.IsZero() {
-		ok, err := r.kv.PutNew(ptr, newRef.ToBlob())
-		if err != nil {
-			return err
-		} else if !ok {
-			return ErrInvalidOldRef
-		}
-	}
-	ok, err := r.kv.CAS(ptr, oldRef.ToBlob(), newRef.ToBlob())
-	if err != nil {
-		return err
-	} else if !ok {
-		return ErrInvalidOldRef
-	}
-	return nil
+	return r.UpdatePointerWith(PointerUpdate{Name: ptr, New: newRef, Old: oldRef})
 }
 
 // GetPointer returns the ref from the given pointer
 func (r *Repo) GetPointer(ptr string) (BlobRef, error) {
-	ptr = "refs/" + ptr
+	ptr = pointerPrefix + ptr
+	has, err := r.kv.Has(ptr)
+	if err != nil {
+		return BlobRef{}, err
+	} else if !has {
+		return BlobRef{}, ErrPointerNotFound
+	}
 	val, err := r.kv.Get(ptr)
 	if err != nil {
 		return BlobRef{}, err
@@ -79,29 +109,111 @@ func (r *Repo) GetPointer(ptr string) (BlobRef, error) {
 	return br, br.FromBlob(val)
 }
 
+// HashAlg returns the algorithm used to compute the refs of new objects.
+//
+// Repositories use Sha256 unless they were migrated with Rehash
+func (r *Repo) HashAlg() (HashAlg, error) {
+	has, err := r.kv.Has(hashAlgKey)
+	if err != nil {
+		return "", err
+	} else if !has {
+		return Sha256, nil
+	}
+	val, err := r.kv.Get(hashAlgKey)
+	if err != nil {
+		return "", err
+	}
+	alg := HashAlg(val.Content)
+	if !alg.valid() {
+		return "", ErrInvalidHashAlgorithm
+	}
+	return alg, nil
+}
+
+// SetVerify enables/disables the integrity check of blobs.
+//
+// When enabled, every blob read or imported is hashed again and ErrCorruptBlob
+// is returned if the content does not match the expected ref. Objects served from the
+// object cache were verified when they were first read.
+func (r *Repo) SetVerify(verify bool) {
+	var v int32
+	if verify {
+		v = 1
+	}
+	atomic.StoreInt32(&r.verify, v)
+}
+
+// verifying returns true if the integrity check was enabled by SetVerify
+func (r *Repo) verifying() bool {
+	return atomic.LoadInt32(&r.verify) == 1
+}
+
 // GetBlob returns the blob from the given BlobRef
 func (r *Repo) GetBlob(ref BlobRef) (Blob, error) {
-	return r.kv.Get(ref.String())
+	b, err := r.kv.Get(ref.String())
+	if err != nil {
+		return Blob{}, err
+	}
+	if r.verifying() {
+		return b, verifyBlob(ref, b)
+	}
+	return b, nil
+}
+
+// ImportBlob stores b under the given ref, this is used to copy objects computed elsewhere.
+//
+// Objects from peers using an older hash algorithm are stored as they are, use
+// ImportCommit to translate their commits to the algorithm of the repository.
+func (r *Repo) ImportBlob(ref BlobRef, b Blob) error {
+	if r.verifying() {
+		if err := verifyBlob(ref, b); err != nil {
+			return err
+		}
+	}
+	_, err := r.kv.PutNew(ref.String(), b)
+	return err
 }
 
 // GetCommit returns the Commit pointed by BlobRef
 func (r *Repo) GetCommit(ref BlobRef) (Commit, error) {
+	if v, ok := r.cache.get(ref); ok {
+		if c, ok := v.(Commit); ok {
+			c.Parents = append(BlobRefList(nil), c.Parents...)
+			return c, nil
+		}
+	}
 	var c Commit
 	b, err := r.GetBlob(ref)
 	if err != nil {
 		return Commit{}, err
 	}
-	return c, c.FromBlob(b)
+	if err := c.FromBlob(b); err != nil {
+		return Commit{}, err
+	}
+	r.cache.put(ref, c)
+	c.Parents = append(BlobRefList(nil), c.Parents...)
+	return c, nil
 }
 
 // GetFile returns the file pointed by BlobRef
 func (r *Repo) GetFile(ref BlobRef) (File, error) {
+	if v, ok := r.cache.get(ref); ok {
+		if f, ok := v.(File); ok {
+			f.Children = append(EdgeList(nil), f.Children...)
+			return f, nil
+		}
+	}
 	var f File
 	b, err := r.GetBlob(ref)
 	if err != nil {
 		return File{}, err
 	}
-	return f, f.FromBlob(b)
+	if err := f.FromBlob(b); err != nil {
+		return File{}, err
+	}
+	r.cache.put(ref, f)
+	f.Children = append(EdgeList(nil), f.Children...)
+	return f, nil
 }
 
 // GetContentAtKey returns the blob at the given key or null if they key does not exist
@@ -110,148 +222,110 @@ func (r *Repo) GetContentAtKey(commitRef BlobRef, key DocumentKey) (Blob, error)
 	if err != nil {
 		return Blob{}, err
 	}
-	file, err := r.GetFile(c.Folder)
+	content, err := findContent(r, c.Folder, key)
 	if err != nil {
 		return Blob{}, err
 	}
+	return r.GetBlob(content)
+}
 
-	steps := key.paths()
-	for _, p := range steps {
-		e, i := file.Children.FindByName(p)
-		if i.NotFound() {
-			return Blob{}, ErrDocumentNotFound
-		}
-		file, err = r.GetFile(e.Ref)
-		if err != nil {
-			return Blob{}, err
-		}
-	}
-
-	blobEdge, i := file.Children.FindByName("blob")
-	if i.NotFound() {
-		return Blob{}, ErrDocumentNotFound
+// List returns the keys of all documents in the given set, sorted by K
+func (r *Repo) List(commitRef BlobRef, set string) ([]DocumentKey, error) {
+	root, err := r.commitRoot(commitRef)
+	if err != nil {
+		return nil, err
 	}
-
-	return r.GetBlob(blobEdge.Ref)
+	var keys []DocumentKey
+	err = listSet(r, root, set, func(k DocumentKey) error {
+		keys = append(keys, k)
+		return nil
+	})
+	return keys, err
 }
 
 // Apply the provided Changeset to the repository and returns the reference to the new commit
+//
+// Changed documents are checked by the validators (see Validator) of the new commit,
+// if any document is rejected ErrValidation is returned and nothing is written.
 func (r *Repo) Apply(cs *Changeset) (BlobRef, error) {
 	cs.parents.SortInPlace()
 	cs.ensureLeafs()
-	var root *File
-	switch len(cs.parents) {
-	case 0:
-		root = &File{}
-	case 1:
-		parent, err := r.GetCommit(cs.parents[0])
-		if err != nil {
-			return BlobRef{}, err
-		}
-		rootFile, err := r.GetFile(parent.Folder)
-		if err != nil {
-			return BlobRef{}, err
-		}
-		root = &rootFile
-	default:
-		return BlobRef{}, errors.New("isodb: cannot handle merge commits yet! sorry 😅")
+	if err := r.preApply(cs); err != nil {
+		return BlobRef{}, err
 	}
-	if len(cs.parents) > 1 {
-		return BlobRef{}, errors.New("cannot handle merge commits at this point. sorry about that")
+	alg, err := r.HashAlg()
+	if err != nil {
+		return BlobRef{}, err
 	}
-	blobs := &kvBlobMap{cache: &inMemBlobMap{}, kv: r.kv}
+	if len(cs.parents) > 1 && cs.ours.IsZero() {
+		return BlobRef{}, errors.New("isodb: merge commits must be created with Repo.Merge")
+	}
+	root, err := r.commitRoot(cs.base())
+	if err != nil {
+		return BlobRef{}, err
+	}
+	blobs := &inMemBlobMap{alg: alg}
 
+	edits := make([]treeEdit, 0, len(cs.leafs)+len(cs.removed))
 	for k, v := range cs.leafs {
-		steps := k.paths()
-
-		blobs.put(v)
-		thisRoot := addPathToLeaf(steps, v.Ref(), blobs)
-		root = mergeRoots(root, thisRoot, blobs)
-		blobs.put(root)
+		if reservedName(k.Set) {
+			return BlobRef{}, ErrReservedSet
+		}
+		edits = append(edits, treeEdit{path: k.paths(), content: blobs.put(v)})
+	}
+	for k := range cs.removed {
+		if reservedName(k.Set) {
+			return BlobRef{}, ErrReservedSet
+		}
+		edits = append(edits, treeEdit{path: k.paths(), remove: true})
+	}
+	if err := r.validateChanges(root, cs); err != nil {
+		return BlobRef{}, err
+	}
+	indexEdits, err := r.indexEdits(root, cs, blobs)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	edits = append(edits, indexEdits...)
+	tb := &treeBuilder{files: r, blobs: blobs}
+	folder, err := tb.build(root, edits)
+	if err != nil {
+		return BlobRef{}, err
 	}
 	c := Commit{
-		Folder:  root.ToBlob().Ref(),
+		Folder:  folder,
 		Parents: cs.parents,
 	}
-	blobs.put(&c)
-	return c.ToBlob().Ref(), r.persistCommit(c, blobs)
+	ref := blobs.put(&c)
+	if err := r.persistBlobs(blobs); err != nil {
+		return BlobRef{}, err
+	}
+	r.postApply(ref)
+	return ref, nil
 }
 
-// actually store the blobs in the underlying database starting from the commit to the children node
-func (r *Repo) persistCommit(c Commit, b blobMap) error {
-	for _, k := range b.keys() {
-		blob := b.raw(nil, k)
-		_, err := r.kv.PutNew(k.String(), blob)
+// persistBlobs writes every object in b to the KV, blobs are written in batches
+// to avoid one transaction per object
+func (r *Repo) persistBlobs(b blobMap) error {
+	const maxBatchBytes = 1 << 20
+	keys := b.keys()
+	for len(keys) > 0 {
+		var batch []BlobRef
+		for size := 0; len(keys) > 0 && size < maxBatchBytes; keys = keys[1:] {
+			batch = append(batch, keys[0])
+			size += len(b.raw(nil, keys[0]).Content)
+		}
+		err := r.kv.Update(func(tx KVTx) error {
+			for _, k := range batch {
+				if err := tx.Put(k.String(), b.raw(nil, k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
-
-func addPathToLeaf(steps []string, blobRef BlobRef, blobs blobMap) *File {
-	leafFile := &File{
-		Name: steps[len(steps)-1],
-		Leaf: true,
-	}
-	leafFile = leafFile.SetFileContent(blobRef)
-
-	steps = steps[:len(steps)-1]
-	blobs.put(leafFile)
-
-	for i := len(steps) - 1; i >= 0; i-- {
-		f := &File{
-			Name:     steps[i],
-			Children: EdgeList{Edge{Name: leafFile.Name, Ref: leafFile.ToBlob().Ref()}},
-		}
-		blobs.put(f)
-		leafFile = f
-	}
-
-	root := &File{
-		Name:     "root",
-		Children: EdgeList{Edge{Name: leafFile.Name, Ref: leafFile.ToBlob().Ref()}},
-	}
-	blobs.put(root)
-	return root
-}
-
-// merge entries from partialRoot into full root and returns fullRoot.
-//
-// nothing is updated in place
-//
-// TODO(andre): think of a way to avoid soo much allocation here
-func mergeRoots(fullRoot, partialRoot *File, blobs blobMap) *File {
-	if len(partialRoot.Children) == 0 {
-		// there is nothing to merge, just ignore it then
-		return fullRoot
-	}
-	switch {
-	case fullRoot.Leaf && partialRoot.Leaf:
-		// we reached the leaf File on both roots
-		// copy the partialRoot (new content) to fullRoot (old content)
-		fullRoot = fullRoot.SetFileContent(partialRoot.GetFileContent())
-		return fullRoot
-	}
-	nextChildrenEdge := partialRoot.Children[0]
-	oldChildrenEdge, idx := fullRoot.Children.FindByName(nextChildrenEdge.Name)
-	if idx.NotFound() {
-		fullRoot = fullRoot.Add(nextChildrenEdge)
-		return fullRoot
-	}
-
-	// old Children exists, now we have to merge them before returning
-	var nextChildrenFile File
-	if !blobs.read(&nextChildrenFile, nextChildrenEdge.Ref) {
-		panic("blobs does not have " + nextChildrenEdge.Ref.String())
-	}
-
-	var oldChildrenFile File
-	if !blobs.read(&oldChildrenFile, oldChildrenEdge.Ref) {
-		panic("blobs does not have " + oldChildrenEdge.Ref.String())
-	}
-
-	f := mergeRoots(&oldChildrenFile, &nextChildrenFile, blobs)
-	blobs.put(f)
-	return fullRoot.Add(Edge{Name: oldChildrenEdge.Name, Ref: f.ToBlob().Ref()})
-}
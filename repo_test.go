@@ -80,3 +80,484 @@ func TestRepo(t *testing.T) {
 		t.Fatalf("Content differs. Got %v", content.Content)
 	}
 }
+
+func TestVerifyBlob(t *testing.T) {
+	repo := newRepo(t)
+	repo.SetVerify(true)
+	blob := NewBlobString("bob bobson")
+	ref := blob.Ref()
+	if err := repo.ImportBlob(ref, blob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetBlob(ref); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.kv.Put(ref.String(), NewBlobString("bob b0bson")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := repo.GetBlob(ref)
+	if corrupt, ok := err.(ErrCorruptBlob); !ok {
+		t.Fatalf("Should have returned ErrCorruptBlob got %v", err)
+	} else if corrupt.Expected != ref || corrupt.Actual != NewBlobString("bob b0bson").Ref() {
+		t.Fatalf("Invalid refs on %v", corrupt)
+	}
+
+	other := NewBlobString("alice anderson")
+	if err := repo.ImportBlob(ref, other); err == nil {
+		t.Fatal("Import should verify the content")
+	}
+}
+
+func TestFsck(t *testing.T) {
+	repo := newRepo(t)
+	cs := NewChangeset()
+	cs.Put(NewRandomKey("people"), NewBlobString("bob bobson"))
+	content := NewBlobString("alice anderson")
+	cs.Put(NewRandomKey("people"), content)
+	cs.Put(NewRandomKey("people"), Blob{})
+	ref, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdatePointer("master/HEAD", ref, BlobRef{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	} else if !report.OK() {
+		t.Fatalf("Unexpected problems: %v", report.Problems)
+	} else if report.Commits != 1 || report.Blobs != 3 {
+		t.Fatalf("Invalid counts on report: %#v", report)
+	}
+
+	if err := repo.kv.Put(content.Ref().String(), NewBlobString("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	report, err = repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(report.Problems) != 1 || report.Problems[0].Kind != FsckHashMismatch {
+		t.Fatalf("Should have found the corrupt blob: %v", report.Problems)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	repo := newRepo(t)
+	bob := NewRandomKey("people")
+	alice := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	first, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs = NewChangeset(first)
+	cs.Put(bob, NewBlobString("Bob Buffon"))
+	cs.Put(alice, NewBlobString("alice anderson"))
+	second, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := repo.Diff(first, second)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 2 {
+		t.Fatalf("Expecting 2 changes got %v", changes)
+	}
+	for _, c := range changes {
+		switch c.Key {
+		case bob:
+			if c.Old != NewBlobString("bob bobson").Ref() || c.New != NewBlobString("Bob Buffon").Ref() {
+				t.Fatalf("Invalid change for bob: %v", c)
+			}
+		case alice:
+			if !c.Old.IsZero() || c.New != NewBlobString("alice anderson").Ref() {
+				t.Fatalf("Invalid change for alice: %v", c)
+			}
+		default:
+			t.Fatalf("Unexpected change %v", c)
+		}
+	}
+
+	if changes, err := repo.Diff(second, second); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 0 {
+		t.Fatalf("Same commit should not have changes: %v", changes)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	repo := newRepo(t)
+	bob := NewRandomKey("people")
+	alice := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	cs.Put(alice, NewBlobString("alice anderson"))
+	first, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs = NewChangeset(first)
+	cs.Delete(bob)
+	second, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetContentAtKey(second, bob); err != ErrDocumentNotFound {
+		t.Fatalf("Document should have been removed, got %v", err)
+	}
+	if keys, err := repo.List(second, "people"); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 1 || keys[0] != alice {
+		t.Fatalf("Expecting only alice got %v", keys)
+	}
+
+	cs = NewChangeset(second)
+	cs.Delete(alice)
+	third, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := repo.commitRoot(third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, err := repo.GetFile(root); err != nil {
+		t.Fatal(err)
+	} else if len(file.Children) != 0 {
+		t.Fatalf("Empty folders should be removed: %v", file.Children)
+	}
+}
+
+func TestPointers(t *testing.T) {
+	repo := newRepo(t)
+	first, err := repo.Apply(NewChangeset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Apply(NewChangeset(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{HeadPointer("main"), TagPointer("v1"), RemotePointer("farm-2", "main")} {
+		if err := repo.UpdatePointer(name, first, BlobRef{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.UpdatePointer(HeadPointer("main"), second, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePointer(HeadPointer("main"), first, first); err != ErrInvalidOldRef {
+		t.Fatalf("CAS should fail, got %v", err)
+	}
+
+	for _, name := range []string{"", "heads/", "heads//main", "heads/../main", "heads/ma in", "remotes/farm-2", "a:b"} {
+		if err := repo.UpdatePointer(name, first, BlobRef{}); err != ErrInvalidPointerName {
+			t.Fatalf("%q should be invalid, got %v", name, err)
+		}
+	}
+
+	ptrs, err := repo.ListPointers("")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ptrs) != 3 || ptrs[0].Name != "heads/main" || ptrs[0].Ref != second {
+		t.Fatalf("Unexpected pointers %v", ptrs)
+	}
+	if ptrs, err := repo.ListPointers(RemotesNamespace); err != nil {
+		t.Fatal(err)
+	} else if len(ptrs) != 1 || ptrs[0].Name != "remotes/farm-2/main" {
+		t.Fatalf("Unexpected pointers %v", ptrs)
+	}
+
+	if err := repo.DeletePointer(TagPointer("v1"), second); err != ErrInvalidOldRef {
+		t.Fatalf("Delete should check the old value, got %v", err)
+	}
+	if err := repo.DeletePointer(TagPointer("v1"), first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetPointer(TagPointer("v1")); err != ErrPointerNotFound {
+		t.Fatalf("Pointer should have been removed, got %v", err)
+	}
+}
+
+func TestPointerLog(t *testing.T) {
+	repo := newRepo(t)
+	first, err := repo.Apply(NewChangeset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Apply(NewChangeset(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePointer("heads/main", first, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdatePointerWith(PointerUpdate{Name: "heads/main", Old: first, New: second, Actor: "bob", Reason: "buggy app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePointer("heads/main", first, first); err != ErrInvalidOldRef {
+		t.Fatalf("CAS should fail, got %v", err)
+	}
+	if err := repo.UpdatePointer("heads/main", BlobRef{}, second); err != ErrEmptyPointerRef {
+		t.Fatalf("Pointers should only be removed by DeletePointer, got %v", err)
+	}
+	if err := repo.UpdatePointer("heads/main", second, second); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := repo.PointerLog("heads/main")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Fatalf("Failed and empty updates should not be logged: %v", entries)
+	} else if entries[1].Seq != 1 || entries[1].Old != first || entries[1].New != second || entries[1].Actor != "bob" {
+		t.Fatalf("Unexpected entry %v", entries[1])
+	}
+
+	if err := repo.ResetPointer("heads/main", 0, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if ref, err := repo.GetPointer("heads/main"); err != nil {
+		t.Fatal(err)
+	} else if ref != first {
+		t.Fatalf("Pointer should be back to %v got %v", first, ref)
+	}
+	if entries, err := repo.PointerLog("heads/main"); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 3 || entries[2].Old != second || entries[2].New != first {
+		t.Fatalf("Reset should be logged: %v", entries)
+	}
+}
+
+func TestUpdatePointers(t *testing.T) {
+	repo := newRepo(t)
+	first, err := repo.Apply(NewChangeset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Apply(NewChangeset(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, remote := HeadPointer("main"), RemotePointer("farm-2", "main")
+	err = repo.UpdatePointers([]PointerUpdate{
+		{Name: main, New: first},
+		{Name: remote, New: first},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UpdatePointers([]PointerUpdate{
+		{Name: main, Old: first, New: second},
+		{Name: remote, Old: second, New: second},
+	})
+	if mismatch, ok := err.(ErrPointerMismatch); !ok {
+		t.Fatalf("Expecting ErrPointerMismatch got %v", err)
+	} else if len(mismatch.Mismatches) != 1 || mismatch.Mismatches[0].Name != remote || mismatch.Mismatches[0].Actual != first {
+		t.Fatalf("Unexpected mismatches %v", mismatch.Mismatches)
+	}
+	if ref, err := repo.GetPointer(main); err != nil {
+		t.Fatal(err)
+	} else if ref != first {
+		t.Fatalf("No pointer should be updated when one fails, got %v", ref)
+	}
+
+	err = repo.UpdatePointers([]PointerUpdate{
+		{Name: main, Old: first, New: second},
+		{Name: remote, Old: first, New: second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{main, remote} {
+		if ref, err := repo.GetPointer(name); err != nil {
+			t.Fatal(err)
+		} else if ref != second {
+			t.Fatalf("%v should point to %v got %v", name, second, ref)
+		}
+	}
+}
+
+func TestChangesetView(t *testing.T) {
+	repo := newRepo(t)
+	bob := NewRandomKey("people")
+	alice := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	cs.Put(alice, NewBlobString("alice anderson"))
+	parent, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := repo.ChangesetView(NewChangeset(parent))
+	if b, err := view.Get(bob); err != nil {
+		t.Fatal(err)
+	} else if string(b.Content) != "bob bobson" {
+		t.Fatalf("Should read from parent, got %v", string(b.Content))
+	}
+	view.Put(bob, NewBlobString("Bob Buffon"))
+	if b, err := view.Get(bob); err != nil {
+		t.Fatal(err)
+	} else if string(b.Content) != "Bob Buffon" {
+		t.Fatalf("Should read staged content, got %v", string(b.Content))
+	}
+	view.Delete(alice)
+	if has, err := view.Has(alice); err != nil {
+		t.Fatal(err)
+	} else if has {
+		t.Fatal("Removed documents should not be visible")
+	}
+	carol := NewRandomKey("people")
+	view.Put(carol, NewBlobString("carol"))
+	if keys, err := view.List("people"); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 2 || (keys[0] != bob && keys[1] != bob) || (keys[0] != carol && keys[1] != carol) {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	repo := newRepo(t)
+	bob := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	first, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := NewRandomKey("people")
+	cs = NewChangeset(first)
+	cs.Put(alice, NewBlobString("alice anderson"))
+	second, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := repo.Snapshot(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := repo.Snapshot(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, err := a.Has(alice); err != nil {
+		t.Fatal(err)
+	} else if has {
+		t.Fatal("alice should not be visible on the first commit")
+	}
+	if content, err := b.Get(alice); err != nil {
+		t.Fatal(err)
+	} else if string(content.Content) != "alice anderson" {
+		t.Fatalf("Content differs. Got %v", content.Content)
+	}
+	if keys, err := b.List("people"); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 2 {
+		t.Fatalf("Expecting 2 keys got %v", keys)
+	}
+	if changes, err := a.Diff(b); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 1 || changes[0].Key != alice || !changes[0].Old.IsZero() {
+		t.Fatalf("Unexpected changes %v", changes)
+	}
+}
+
+func TestObjectCache(t *testing.T) {
+	repo := newRepo(t)
+	repo.SetCacheSize(2)
+	bob := NewRandomKey("people")
+	cs := NewChangeset()
+	cs.Put(bob, NewBlobString("bob bobson"))
+	ref, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetCommit(ref); err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.GetCommit(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := repo.CacheStats(); stats.Hits != 1 || stats.Size != 1 {
+		t.Fatalf("Unexpected stats %#v", stats)
+	}
+
+	root, err := repo.GetFile(c.Folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Children[0].Name = "changed"
+	if root, err := repo.GetFile(c.Folder); err != nil {
+		t.Fatal(err)
+	} else if root.Children[0].Name != "people" {
+		t.Fatal("Cached objects should not be modified by callers")
+	}
+
+	if _, err := repo.GetContentAtKey(ref, bob); err != nil {
+		t.Fatal(err)
+	}
+	if stats := repo.CacheStats(); stats.Size != 2 {
+		t.Fatalf("Cache should be bounded, got %#v", stats)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	repo := newRepo(t)
+	cs := NewChangeset()
+	keys := make([]DocumentKey, 1000)
+	for i := range keys {
+		keys[i] = NewRandomKey("people")
+		cs.Put(keys[i], NewBlobString(keys[i].String()))
+	}
+	first, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed, err := repo.List(first, "people"); err != nil {
+		t.Fatal(err)
+	} else if len(listed) != len(keys) {
+		t.Fatalf("Expecting %v keys got %v", len(keys), len(listed))
+	}
+
+	cs = NewChangeset(first)
+	for _, k := range keys[:500] {
+		cs.Delete(k)
+	}
+	cs.Put(keys[999], NewBlobString("updated"))
+	second, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := repo.Diff(first, second)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 501 {
+		t.Fatalf("Expecting 501 changes got %v", len(changes))
+	}
+	if content, err := repo.GetContentAtKey(second, keys[999]); err != nil {
+		t.Fatal(err)
+	} else if string(content.Content) != "updated" {
+		t.Fatalf("Content differs. Got %v", content.Content)
+	}
+	if err := repo.UpdatePointer("heads/main", second, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := repo.Fsck(); err != nil {
+		t.Fatal(err)
+	} else if !report.OK() {
+		t.Fatalf("Unexpected problems %v", report.Problems)
+	}
+}
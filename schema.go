@@ -0,0 +1,436 @@
+package isodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Schema associates a JSON Schema with a Set, every JSON document written to Set
+	// must conform to it.
+	//
+	// Schemas are stored as documents in SchemasSet and, like validators, Apply checks
+	// the changed documents against the schemas of the base commit and of the commit
+	// being created. Each Set can have a single schema.
+	//
+	// The following subset of draft 2020-12 is supported, other keywords are ignored:
+	//
+	//	type, enum, const
+	//	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+	//	minLength, maxLength, pattern
+	//	items, prefixItems, minItems, maxItems, uniqueItems
+	//	properties, additionalProperties, required, minProperties, maxProperties
+	//	allOf, anyOf, oneOf, not
+	//	$ref (only to locations inside the same schema, like #/$defs/name)
+	Schema struct {
+		Set    string          `json:"set"`
+		Schema json.RawMessage `json:"schema"`
+	}
+
+	// jsonSchema is a compiled JSON Schema
+	jsonSchema struct {
+		// always is set for the boolean schemas true and false
+		always *bool
+
+		types    []string
+		enum     []interface{}
+		hasConst bool
+		constant interface{}
+		// limits holds the numeric keywords (minimum, maxLength...) present in the schema
+		limits  map[string]float64
+		pattern *regexp.Regexp
+		unique  bool
+
+		items       *jsonSchema
+		prefixItems []*jsonSchema
+
+		properties map[string]*jsonSchema
+		additional *jsonSchema
+		required   []string
+
+		allOf, anyOf, oneOf []*jsonSchema
+		not                 *jsonSchema
+
+		// ref is the schema referenced by $ref
+		ref *jsonSchema
+	}
+
+	// schemaCompiler resolves $ref inside a single schema document
+	schemaCompiler struct {
+		root interface{}
+		refs map[string]*jsonSchema
+		// pending refs to compile
+		pending []string
+	}
+)
+
+const (
+	// SchemasSet contains the Schema definitions of the repository
+	SchemasSet = "_schemas"
+
+	// maxSchemaDepth limits the nesting of schemas (and $ref) used to check a document
+	maxSchemaDepth = 128
+)
+
+var (
+	schemaLimits = map[string]bool{
+		"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true, "multipleOf": true,
+		"minLength": true, "maxLength": true, "minItems": true, "maxItems": true,
+		"minProperties": true, "maxProperties": true,
+	}
+
+	schemaTypes = map[string]bool{
+		"null": true, "boolean": true, "number": true, "integer": true,
+		"string": true, "array": true, "object": true,
+	}
+)
+
+// ToBlob encodes the schema to be stored in SchemasSet
+func (s Schema) ToBlob() Blob {
+	blob, err := json.Marshal(s)
+	if err != nil {
+		panic("this should never ever happen! " + err.Error())
+	}
+	return Blob{Content: blob}
+}
+
+// compileSchema parses the JSON Schema in raw
+func compileSchema(raw json.RawMessage) (*jsonSchema, error) {
+	c := &schemaCompiler{refs: make(map[string]*jsonSchema)}
+	if err := json.Unmarshal(raw, &c.root); err != nil {
+		return nil, errors.Wrap(err, "isodb: invalid schema")
+	}
+	s := &jsonSchema{}
+	if err := c.compile(s, c.root, "#"); err != nil {
+		return nil, err
+	}
+	for len(c.pending) > 0 {
+		ptr := c.pending[0]
+		c.pending = c.pending[1:]
+		target, ok := lookupJSONPointer(c.root, ptr)
+		if !ok {
+			return nil, errors.Errorf("isodb: invalid schema: $ref #%v not found", ptr)
+		}
+		if err := c.compile(c.refs[ptr], target, "#"+ptr); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// compile the schema v (found at the location at) into s
+func (c *schemaCompiler) compile(s *jsonSchema, v interface{}, at string) error {
+	invalid := func(kw string, expected string) error {
+		return errors.Errorf("isodb: invalid schema: %v/%v must be %v", at, kw, expected)
+	}
+	sub := func(v interface{}, at string) (*jsonSchema, error) {
+		s := &jsonSchema{}
+		return s, c.compile(s, v, at)
+	}
+	list := func(kw string, v interface{}) ([]*jsonSchema, error) {
+		items, ok := v.([]interface{})
+		if !ok || len(items) == 0 {
+			return nil, invalid(kw, "a non-empty array of schemas")
+		}
+		out := make([]*jsonSchema, len(items))
+		for i, item := range items {
+			var err error
+			if out[i], err = sub(item, fmt.Sprintf("%v/%v/%v", at, kw, i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	var obj map[string]interface{}
+	switch v := v.(type) {
+	case bool:
+		s.always = &v
+		return nil
+	case map[string]interface{}:
+		obj = v
+	default:
+		return errors.Errorf("isodb: invalid schema: %v must be an object or a boolean", at)
+	}
+
+	for kw, arg := range obj {
+		var err error
+		switch kw {
+		case "type":
+			switch arg := arg.(type) {
+			case string:
+				s.types = []string{arg}
+			case []interface{}:
+				for _, t := range arg {
+					if t, ok := t.(string); ok {
+						s.types = append(s.types, t)
+					}
+				}
+			}
+			if len(s.types) == 0 {
+				return invalid(kw, "a type name or an array of type names")
+			}
+			for _, t := range s.types {
+				if !schemaTypes[t] {
+					return invalid(kw, "a valid type")
+				}
+			}
+		case "enum":
+			var ok bool
+			if s.enum, ok = arg.([]interface{}); !ok {
+				return invalid(kw, "an array")
+			}
+		case "const":
+			s.hasConst, s.constant = true, arg
+		case "pattern":
+			str, ok := arg.(string)
+			if !ok {
+				return invalid(kw, "a string")
+			}
+			if s.pattern, err = regexp.Compile(str); err != nil {
+				return invalid(kw, "a valid regular expression")
+			}
+		case "uniqueItems":
+			var ok bool
+			if s.unique, ok = arg.(bool); !ok {
+				return invalid(kw, "a boolean")
+			}
+		case "items":
+			s.items, err = sub(arg, at+"/items")
+		case "additionalProperties":
+			s.additional, err = sub(arg, at+"/additionalProperties")
+		case "not":
+			s.not, err = sub(arg, at+"/not")
+		case "prefixItems":
+			s.prefixItems, err = list(kw, arg)
+		case "allOf":
+			s.allOf, err = list(kw, arg)
+		case "anyOf":
+			s.anyOf, err = list(kw, arg)
+		case "oneOf":
+			s.oneOf, err = list(kw, arg)
+		case "properties":
+			props, ok := arg.(map[string]interface{})
+			if !ok {
+				return invalid(kw, "an object")
+			}
+			s.properties = make(map[string]*jsonSchema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = sub(prop, at+"/properties/"+escapeJSONPointer(name)); err != nil {
+					return err
+				}
+			}
+		case "required":
+			names, ok := arg.([]interface{})
+			if !ok {
+				return invalid(kw, "an array of strings")
+			}
+			for _, n := range names {
+				name, ok := n.(string)
+				if !ok {
+					return invalid(kw, "an array of strings")
+				}
+				s.required = append(s.required, name)
+			}
+		case "$ref":
+			ref, ok := arg.(string)
+			if !ok || !strings.HasPrefix(ref, "#") || validJSONPointer(ref[1:]) != nil {
+				return invalid(kw, "a reference inside the schema (#/...)")
+			}
+			ptr := ref[1:]
+			if s.ref = c.refs[ptr]; s.ref == nil {
+				s.ref = &jsonSchema{}
+				c.refs[ptr] = s.ref
+				c.pending = append(c.pending, ptr)
+			}
+		default:
+			if !schemaLimits[kw] {
+				continue
+			}
+			n, ok := arg.(float64)
+			if !ok || (kw == "multipleOf" && n <= 0) {
+				return invalid(kw, "a number")
+			}
+			if s.limits == nil {
+				s.limits = make(map[string]float64)
+			}
+			s.limits[kw] = n
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate returns the reasons why v (found at the location at) does not conform to s
+func (s *jsonSchema) validate(v interface{}, at string, depth int) []string {
+	if depth > maxSchemaDepth {
+		return []string{at + ": schema nesting is too deep"}
+	}
+	if s.always != nil {
+		if !*s.always {
+			return []string{at + ": not allowed"}
+		}
+		return nil
+	}
+	var errs []string
+	fail := func(msg string, args ...interface{}) {
+		errs = append(errs, at+": "+fmt.Sprintf(msg, args...))
+	}
+	if s.ref != nil {
+		errs = append(errs, s.ref.validate(v, at, depth+1)...)
+	}
+	if len(s.types) > 0 && !s.hasType(v) {
+		fail("must be %v", strings.Join(s.types, " or "))
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			found = found || compareJSON(v, e) == 0
+		}
+		if !found {
+			fail("must be one of the enum values")
+		}
+	}
+	if s.hasConst && compareJSON(v, s.constant) != 0 {
+		fail("must be the const value")
+	}
+
+	limit := func(kw string, value float64, violated func(n, limit float64) bool, msg string) {
+		if n, ok := s.limits[kw]; ok && violated(value, n) {
+			fail(msg, n)
+		}
+	}
+	less := func(a, b float64) bool { return a < b }
+	greater := func(a, b float64) bool { return a > b }
+	switch v := v.(type) {
+	case float64:
+		limit("minimum", v, less, "must be >= %v")
+		limit("maximum", v, greater, "must be <= %v")
+		limit("exclusiveMinimum", v, func(a, b float64) bool { return a <= b }, "must be > %v")
+		limit("exclusiveMaximum", v, func(a, b float64) bool { return a >= b }, "must be < %v")
+		limit("multipleOf", v, func(a, b float64) bool {
+			q := a / b
+			return math.Abs(q-math.Round(q)) > 1e-9
+		}, "must be a multiple of %v")
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		limit("minLength", n, less, "must have at least %v characters")
+		limit("maxLength", n, greater, "must have at most %v characters")
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %v", s.pattern)
+		}
+	case []interface{}:
+		n := float64(len(v))
+		limit("minItems", n, less, "must have at least %v items")
+		limit("maxItems", n, greater, "must have at most %v items")
+		for i, item := range v {
+			itemAt := fmt.Sprintf("%v/%v", at, i)
+			if i < len(s.prefixItems) {
+				errs = append(errs, s.prefixItems[i].validate(item, itemAt, depth+1)...)
+			} else if s.items != nil {
+				errs = append(errs, s.items.validate(item, itemAt, depth+1)...)
+			}
+			if s.unique {
+				for _, other := range v[:i] {
+					if compareJSON(item, other) == 0 {
+						fail("items must be unique")
+						break
+					}
+				}
+			}
+		}
+	case map[string]interface{}:
+		n := float64(len(v))
+		limit("minProperties", n, less, "must have at least %v properties")
+		limit("maxProperties", n, greater, "must have at most %v properties")
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("%v is required", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propAt := at + "/" + escapeJSONPointer(name)
+			if prop, ok := s.properties[name]; ok {
+				errs = append(errs, prop.validate(v[name], propAt, depth+1)...)
+			} else if s.additional != nil {
+				errs = append(errs, s.additional.validate(v[name], propAt, depth+1)...)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		errs = append(errs, sub.validate(v, at, depth+1)...)
+	}
+	if s.anyOf != nil && s.matches(s.anyOf, v, at, depth) == 0 {
+		fail("must match at least one schema of anyOf")
+	}
+	if s.oneOf != nil && s.matches(s.oneOf, v, at, depth) != 1 {
+		fail("must match exactly one schema of oneOf")
+	}
+	if s.not != nil && len(s.not.validate(v, at, depth+1)) == 0 {
+		fail("must not match the schema of not")
+	}
+	return errs
+}
+
+// matches returns how many schemas accept v
+func (s *jsonSchema) matches(schemas []*jsonSchema, v interface{}, at string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(sub.validate(v, at, depth+1)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *jsonSchema) hasType(v interface{}) bool {
+	for _, t := range s.types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || t == "integer" && v == math.Trunc(v) {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// escapeJSONPointer escapes name to be used as a token of a JSON pointer
+func escapeJSONPointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
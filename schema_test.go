@@ -0,0 +1,178 @@
+package isodb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name", "age"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"tags": {"type": "array", "items": {"enum": ["a", "b", "c"]}, "uniqueItems": true, "maxItems": 2},
+			"point": {"prefixItems": [{"type": "number"}, {"type": "number"}], "items": false},
+			"kind": {"oneOf": [{"const": "x"}, {"type": "number", "multipleOf": 0.1}]},
+			"parent": {"$ref": "#"},
+			"email": {"$ref": "#/$defs/email"}
+		},
+		"additionalProperties": false,
+		"not": {"required": ["forbidden"]},
+		"$defs": {"email": {"type": "string", "pattern": "@"}}
+	}`
+	compiled, err := compileSchema(json.RawMessage(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name": "bob", "age": 30}`, true},
+		{`{"name": "bob", "age": 30, "tags": ["a", "c"], "point": [1, 2], "kind": 0.3}`, true},
+		{`{"name": "bob", "age": 30, "kind": "x", "email": "bob@example.com"}`, true},
+		{`{"name": "bob", "age": 30, "parent": {"name": "al", "age": 60}}`, true},
+		{`{"name": "bob"}`, false},
+		{`[]`, false},
+		{`{"name": "", "age": 30}`, false},
+		{`{"name": "robert", "age": 30}`, false},
+		{`{"name": "Bob", "age": 30}`, false},
+		{`{"name": "bob", "age": 30.5}`, false},
+		{`{"name": "bob", "age": -1}`, false},
+		{`{"name": "bob", "age": 150}`, false},
+		{`{"name": "bob", "age": 30, "tags": ["a", "a"]}`, false},
+		{`{"name": "bob", "age": 30, "tags": ["d"]}`, false},
+		{`{"name": "bob", "age": 30, "tags": ["a", "b", "c"]}`, false},
+		{`{"name": "bob", "age": 30, "point": [1, 2, 3]}`, false},
+		{`{"name": "bob", "age": 30, "point": ["1"]}`, false},
+		{`{"name": "bob", "age": 30, "kind": 0.35}`, false},
+		{`{"name": "bob", "age": 30, "kind": "y"}`, false},
+		{`{"name": "bob", "age": 30, "parent": {"name": "al"}}`, false},
+		{`{"name": "bob", "age": 30, "email": "bob"}`, false},
+		{`{"name": "bob", "age": 30, "other": true}`, false},
+	} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(tc.doc), &v); err != nil {
+			t.Fatal(err)
+		}
+		errs := compiled.validate(v, "#", 0)
+		if tc.valid != (len(errs) == 0) {
+			t.Errorf("%v should be valid=%v got %v", tc.doc, tc.valid, errs)
+		}
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"type": "text"}`,
+		`{"minimum": "1"}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"$ref": "#/missing"}`,
+		`{"$ref": "http://example.com/schema"}`,
+	} {
+		if _, err := compileSchema(json.RawMessage(invalid)); err == nil {
+			t.Errorf("%v should be rejected", invalid)
+		}
+	}
+
+	// cycles made only of references stop at the nesting limit
+	loop, err := compileSchema(json.RawMessage(`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := loop.validate(nil, "#", 0); len(errs) == 0 {
+		t.Fatal("Reference loops should not validate")
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	repo := newRepo(t)
+	cs := NewChangeset()
+	peopleSchema := NewRandomKey(SchemasSet)
+	cs.Put(peopleSchema, Schema{Set: "people", Schema: json.RawMessage(`{"required": ["name"]}`)}.ToBlob())
+	bob := NewRandomKey("people")
+	cs.Put(bob, NewBlobString(`{"name": "bob", "age": 30}`))
+	commit, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid, notJSON := NewRandomKey("people"), NewRandomKey("people")
+	cs = NewChangeset(commit)
+	cs.Put(invalid, NewBlobString(`{"age": 30}`))
+	cs.Put(notJSON, NewBlobString(`bob`))
+	cs.Delete(bob)
+	_, err = repo.Apply(cs)
+	report, ok := err.(ErrValidation)
+	if !ok || len(report.Errors) != 2 {
+		t.Fatalf("Apply should reject the documents, got %v", err)
+	}
+	for _, e := range report.Errors {
+		if e.Key == invalid && e.Reason != "#: name is required" || e.Key == notJSON && e.Reason != "document is not JSON" {
+			t.Fatalf("Unexpected error %v", e)
+		}
+	}
+
+	schema := NewRandomKey(SchemasSet)
+	cs = NewChangeset(commit)
+	cs.Put(schema, Schema{Set: "people", Schema: json.RawMessage(`{"required": ["name", "email"]}`)}.ToBlob())
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Apply should reject two schemas for the same set")
+	}
+	cs = NewChangeset(commit)
+	cs.Put(schema, Schema{Set: "other", Schema: json.RawMessage(`{"type": "string"}`)}.ToBlob())
+	cs.Put(NewRandomKey("other"), NewBlobString(`"hello"`))
+	commit, err = repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs = NewChangeset(commit)
+	cs.Put(NewRandomKey("other"), NewBlobString(`1`))
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Apply should reject documents which do not match the new schema")
+	}
+	if err := repo.ValidateCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	// stricter schemas only apply to the documents changed with them
+	cs = NewChangeset(commit)
+	cs.Put(peopleSchema, Schema{Set: "people", Schema: json.RawMessage(`{"required": ["name", "email"]}`)}.ToBlob())
+	commit, err = repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ValidateCommit(commit)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 1 || report.Errors[0].Key != bob {
+		t.Fatalf("ValidateCommit should report %v got %v", bob, err)
+	}
+
+	// documents must also match the schema of the base commit
+	cs = NewChangeset(commit)
+	cs.Delete(peopleSchema)
+	cs.Put(invalid, NewBlobString(`{"age": 30}`))
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Removing a schema should not disable it for the same changeset")
+	}
+	cs = NewChangeset(commit)
+	cs.Put(peopleSchema, Schema{Set: "people", Schema: json.RawMessage(`{}`)}.ToBlob())
+	cs.Put(invalid, NewBlobString(`{"age": 30}`))
+	_, err = repo.Apply(cs)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 1 || report.Errors[0].Key != invalid {
+		t.Fatalf("Relaxing a schema should not accept documents of the same changeset got %v", err)
+	}
+
+	// schemas of SchemasSet protect the schemas
+	cs = NewChangeset(commit)
+	cs.Put(NewRandomKey(SchemasSet), Schema{Set: SchemasSet, Schema: json.RawMessage(`{"properties": {"set": {"not": {"const": "people"}}}}`)}.ToBlob())
+	if commit, err = repo.Apply(cs); err != nil {
+		t.Fatal(err)
+	}
+	cs = NewChangeset(commit)
+	cs.Put(peopleSchema, Schema{Set: "people", Schema: json.RawMessage(`{}`)}.ToBlob())
+	_, err = repo.Apply(cs)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 1 || report.Errors[0].Key != peopleSchema {
+		t.Fatalf("Schemas should check the changes to SchemasSet got %v", err)
+	}
+}
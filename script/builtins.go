@@ -0,0 +1,510 @@
+package script
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtins available to every program
+var builtins map[symbol]interface{}
+
+func init() {
+	builtins = map[symbol]interface{}{
+		"+":   arith(func(a, b float64) float64 { return a + b }),
+		"-":   arith(func(a, b float64) float64 { return a - b }),
+		"*":   arith(func(a, b float64) float64 { return a * b }),
+		"/":   builtin(divide),
+		"mod": builtin(mod),
+		"=":   builtin(func(_ *interp, args []interface{}) (interface{}, error) { return allPairs(args, equal) }),
+		"!=": builtin(func(_ *interp, args []interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, errorf("!= requires 2 arguments")
+			}
+			return !equal(args[0], args[1]), nil
+		}),
+		"<":  compare(func(c int) bool { return c < 0 }),
+		"<=": compare(func(c int) bool { return c <= 0 }),
+		">":  compare(func(c int) bool { return c > 0 }),
+		">=": compare(func(c int) bool { return c >= 0 }),
+		"not": builtin(func(_ *interp, args []interface{}) (interface{}, error) {
+			return len(args) == 1 && !truthy(args[0]), nil
+		}),
+
+		"max":  builtin(func(_ *interp, args []interface{}) (interface{}, error) { return extreme(args, 1) }),
+		"min":  builtin(func(_ *interp, args []interface{}) (interface{}, error) { return extreme(args, -1) }),
+		"type": builtin(func(_ *interp, args []interface{}) (interface{}, error) { return typeName(argN(args, 0)), nil }),
+		"str":  builtin(str),
+		"fail": builtin(func(_ *interp, args []interface{}) (interface{}, error) {
+			msg, _ := str(nil, args)
+			return nil, Error{Msg: msg.(string), Fail: true}
+		}),
+
+		"list": builtin(func(in *interp, args []interface{}) (interface{}, error) {
+			return append([]interface{}{}, args...), in.charge(len(args))
+		}),
+		"dict":   builtin(dict),
+		"len":    builtin(length),
+		"get":    builtin(get),
+		"has":    builtin(has),
+		"put":    builtin(put),
+		"del":    builtin(del),
+		"keys":   builtin(keys),
+		"merge":  builtin(merge),
+		"append": builtin(appendList),
+		"concat": builtin(concat),
+		"union":  builtin(union),
+		"map":    builtin(mapList),
+		"filter": builtin(filterList),
+		"reduce": builtin(reduceList),
+	}
+}
+
+func arith(op func(a, b float64) float64) builtin {
+	return func(_ *interp, args []interface{}) (interface{}, error) {
+		nums, err := numbers(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(nums) == 0 {
+			return nil, errorf("arithmetic requires at least one argument")
+		}
+		acc := nums[0]
+		for _, n := range nums[1:] {
+			acc = op(acc, n)
+		}
+		return acc, nil
+	}
+}
+
+func divide(_ *interp, args []interface{}) (interface{}, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return nil, err
+	} else if len(nums) != 2 {
+		return nil, errorf("/ requires 2 arguments")
+	} else if nums[1] == 0 {
+		return nil, errorf("division by zero")
+	}
+	return nums[0] / nums[1], nil
+}
+
+func mod(_ *interp, args []interface{}) (interface{}, error) {
+	nums, err := numbers(args)
+	if err != nil {
+		return nil, err
+	} else if len(nums) != 2 {
+		return nil, errorf("mod requires 2 arguments")
+	} else if nums[1] == 0 {
+		return nil, errorf("division by zero")
+	}
+	return math.Mod(nums[0], nums[1]), nil
+}
+
+func numbers(args []interface{}) ([]float64, error) {
+	nums := make([]float64, len(args))
+	for i, a := range args {
+		n, ok := a.(float64)
+		if !ok {
+			return nil, errorf("expecting number got %v", typeName(a))
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+func allPairs(args []interface{}, fn func(a, b interface{}) bool) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errorf("comparison requires at least 2 arguments")
+	}
+	for i := 1; i < len(args); i++ {
+		if !fn(args[i-1], args[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compare(ok func(int) bool) builtin {
+	return func(_ *interp, args []interface{}) (interface{}, error) {
+		var err error
+		res, cerr := allPairs(args, func(a, b interface{}) bool {
+			var c int
+			if c, err = order(a, b); err != nil {
+				return false
+			}
+			return ok(c)
+		})
+		if cerr != nil {
+			return nil, cerr
+		}
+		return res, err
+	}
+}
+
+// order compares numbers or strings
+func order(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	}
+	return 0, errorf("cannot compare %v and %v", typeName(a), typeName(b))
+}
+
+func extreme(args []interface{}, sign int) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errorf("max and min require at least one argument")
+	}
+	best := args[0]
+	for _, a := range args[1:] {
+		c, err := order(a, best)
+		if err != nil {
+			return nil, err
+		}
+		if c*sign > 0 {
+			best = a
+		}
+	}
+	return best, nil
+}
+
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if other, ok := b[k]; !ok || !equal(v, other) {
+				return false
+			}
+		}
+		return true
+	case *lambda:
+		return a == b
+	case builtin:
+		return false
+	}
+	return a == b
+}
+
+func str(in *interp, args []interface{}) (interface{}, error) {
+	var sb strings.Builder
+	for _, a := range args {
+		switch a := a.(type) {
+		case string:
+			sb.WriteString(a)
+		case float64:
+			sb.WriteString(strconv.FormatFloat(a, 'f', -1, 64))
+		case bool:
+			sb.WriteString(strconv.FormatBool(a))
+		case nil:
+			sb.WriteString("nil")
+		default:
+			sb.WriteString(typeName(a))
+		}
+	}
+	return sb.String(), in.charge(sb.Len())
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "dict"
+	}
+	return "fn"
+}
+
+func argN(args []interface{}, n int) interface{} {
+	if n < len(args) {
+		return args[n]
+	}
+	return nil
+}
+
+func dict(in *interp, args []interface{}) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, errorf("dict requires key value pairs")
+	}
+	out := make(map[string]interface{}, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		k, ok := args[i].(string)
+		if !ok {
+			return nil, errorf("dict keys must be strings")
+		}
+		out[k] = args[i+1]
+	}
+	return out, in.charge(len(out))
+}
+
+func length(_ *interp, args []interface{}) (interface{}, error) {
+	switch v := argN(args, 0).(type) {
+	case string:
+		return float64(len(v)), nil
+	case []interface{}:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	case nil:
+		return float64(0), nil
+	}
+	return nil, errorf("len requires a string, list or dict")
+}
+
+// get returns the value at the key (dict) or index (list), or the optional default
+func get(_ *interp, args []interface{}) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errorf("get requires a collection, a key and an optional default")
+	}
+	switch c := args[0].(type) {
+	case map[string]interface{}:
+		if k, ok := args[1].(string); ok {
+			if v, found := c[k]; found {
+				return v, nil
+			}
+		}
+	case []interface{}:
+		if i, ok := args[1].(float64); ok && i >= 0 && int(i) < len(c) && i == math.Trunc(i) {
+			return c[int(i)], nil
+		}
+	}
+	return argN(args, 2), nil
+}
+
+func has(_ *interp, args []interface{}) (interface{}, error) {
+	c, ok := argN(args, 0).(map[string]interface{})
+	k, isStr := argN(args, 1).(string)
+	if !ok || !isStr {
+		return false, nil
+	}
+	_, found := c[k]
+	return found, nil
+}
+
+func copyDict(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return make(map[string]interface{}), nil
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errorf("expecting dict got %v", typeName(v))
+	}
+	out := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func put(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errorf("put requires a dict, a key and a value")
+	}
+	out, err := copyDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	k, ok := args[1].(string)
+	if !ok {
+		return nil, errorf("dict keys must be strings")
+	}
+	out[k] = args[2]
+	return out, in.charge(len(out))
+}
+
+func del(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errorf("del requires a dict and a key")
+	}
+	out, err := copyDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	k, _ := args[1].(string)
+	delete(out, k)
+	return out, in.charge(len(out))
+}
+
+func keys(in *interp, args []interface{}) (interface{}, error) {
+	d, ok := argN(args, 0).(map[string]interface{})
+	if !ok {
+		return nil, errorf("keys requires a dict")
+	}
+	names := make([]string, 0, len(d))
+	for k := range d {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	out := make([]interface{}, len(names))
+	for i, k := range names {
+		out[i] = k
+	}
+	return out, in.charge(len(out))
+}
+
+// merge returns a dict with the keys of every argument, later arguments win
+func merge(in *interp, args []interface{}) (interface{}, error) {
+	out := make(map[string]interface{})
+	for _, a := range args {
+		d, err := copyDict(a)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range d {
+			out[k] = v
+		}
+	}
+	return out, in.charge(len(out))
+}
+
+func list(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	}
+	return nil, errorf("expecting list got %v", typeName(v))
+}
+
+func appendList(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errorf("append requires a list")
+	}
+	l, err := list(args[0])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, 0, len(l)+len(args)-1)
+	out = append(append(out, l...), args[1:]...)
+	return out, in.charge(len(out))
+}
+
+func concat(in *interp, args []interface{}) (interface{}, error) {
+	out := []interface{}{}
+	for _, a := range args {
+		l, err := list(a)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l...)
+	}
+	return out, in.charge(len(out))
+}
+
+// union concatenates the lists skipping values already present
+func union(in *interp, args []interface{}) (interface{}, error) {
+	out := []interface{}{}
+	for _, a := range args {
+		l, err := list(a)
+		if err != nil {
+			return nil, err
+		}
+	next:
+		for _, v := range l {
+			if err := in.charge(len(out) + 1); err != nil {
+				return nil, err
+			}
+			for _, existing := range out {
+				if equal(v, existing) {
+					continue next
+				}
+			}
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func mapList(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errorf("map requires a function and a list")
+	}
+	l, err := list(args[1])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, 0, len(l))
+	for _, v := range l {
+		res, err := in.call(args[0], []interface{}{v})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+func filterList(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errorf("filter requires a function and a list")
+	}
+	l, err := list(args[1])
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	for _, v := range l {
+		keep, err := in.call(args[0], []interface{}{v})
+		if err != nil {
+			return nil, err
+		}
+		if truthy(keep) {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// reduceList calls (fn acc item) for every item, starting with init
+func reduceList(in *interp, args []interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errorf("reduce requires a function, an initial value and a list")
+	}
+	l, err := list(args[2])
+	if err != nil {
+		return nil, err
+	}
+	acc := args[1]
+	for _, v := range l {
+		if acc, err = in.call(args[0], []interface{}{acc, v}); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
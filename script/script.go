@@ -0,0 +1,452 @@
+// Package script implements a small deterministic language used to run code stored
+// as documents, like the conflict resolvers of isodb.
+//
+// Programs are S-expressions:
+//
+//	; comments start with a semicolon
+//	(define (newest a b) (if (> (get a "updated") (get b "updated")) a b))
+//	(newest ours theirs)
+//
+// Values are the ones produced by decoding JSON (nil, bool, float64, string,
+// []interface{} and map[string]interface{}) plus functions. Values are never modified,
+// functions like put and append return updated copies.
+//
+// Programs cannot perform I/O, read the clock or generate random numbers and every
+// execution is limited to a number of steps, so the same program with the same inputs
+// always produces the same result.
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// Program is a parsed script, it can be executed many times and shared between goroutines
+	Program struct {
+		exprs []interface{}
+	}
+
+	// Error is returned when the program fails, Fail is true if it was caused by
+	// a call to the fail function
+	Error struct {
+		Msg  string
+		Fail bool
+	}
+
+	symbol string
+
+	// sexpr is a list in the source code, data lists are []interface{}
+	sexpr []interface{}
+
+	lambda struct {
+		params []symbol
+		body   []interface{}
+		env    *env
+	}
+
+	builtin func(in *interp, args []interface{}) (interface{}, error)
+
+	env struct {
+		vars   map[symbol]interface{}
+		parent *env
+	}
+
+	interp struct {
+		steps    int
+		maxSteps int
+		depth    int
+	}
+
+	strErr string
+)
+
+const (
+	// DefaultMaxSteps is used when Run receives a non-positive limit
+	DefaultMaxSteps = 100000
+
+	// ErrStepLimit indicates that the program did not finish within the allowed steps
+	ErrStepLimit = strErr("script: step limit exceeded")
+
+	// maxDepth limits the nesting of calls
+	maxDepth = 256
+)
+
+// Parse the source code of a program
+func Parse(src string) (*Program, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{}
+	for len(tokens) > 0 {
+		var expr interface{}
+		if expr, tokens, err = read(tokens); err != nil {
+			return nil, err
+		}
+		p.exprs = append(p.exprs, expr)
+	}
+	return p, nil
+}
+
+// Run executes the program with vars defined as global variables and returns the value
+// of the last expression. The result cannot contain functions.
+//
+// maxSteps limits the number of expressions evaluated, DefaultMaxSteps is used
+// if maxSteps is not positive.
+func (p *Program) Run(vars map[string]interface{}, maxSteps int) (interface{}, error) {
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	in := &interp{maxSteps: maxSteps}
+	e := &env{vars: make(map[symbol]interface{}, len(vars)), parent: &env{vars: builtins}}
+	for k, v := range vars {
+		e.vars[symbol(k)] = v
+	}
+	var result interface{}
+	for _, expr := range p.exprs {
+		var err error
+		if result, err = in.eval(expr, e); err != nil {
+			return nil, err
+		}
+	}
+	if !isData(result) {
+		return nil, errorf("result must not contain functions")
+	}
+	return result, nil
+}
+
+func (in *interp) eval(x interface{}, e *env) (interface{}, error) {
+	if in.steps++; in.steps > in.maxSteps {
+		return nil, ErrStepLimit
+	}
+	switch x := x.(type) {
+	case symbol:
+		return e.lookup(x)
+	case sexpr:
+		return in.evalList(x, e)
+	}
+	return x, nil
+}
+
+func (in *interp) evalList(x sexpr, e *env) (interface{}, error) {
+	if len(x) == 0 {
+		return nil, nil
+	}
+	if head, ok := x[0].(symbol); ok {
+		switch head {
+		case "if":
+			return in.evalIf(x, e)
+		case "define":
+			return in.evalDefine(x, e)
+		case "fn":
+			if len(x) < 3 {
+				return nil, errorf("fn requires parameters and a body")
+			}
+			return newLambda(x[1], x[2:], e)
+		case "let":
+			return in.evalLet(x, e)
+		case "do":
+			return in.evalBody(x[1:], e)
+		case "and", "or":
+			var v interface{} = head == "and"
+			for _, arg := range x[1:] {
+				var err error
+				if v, err = in.eval(arg, e); err != nil {
+					return nil, err
+				}
+				if truthy(v) != (head == "and") {
+					return v, nil
+				}
+			}
+			return v, nil
+		}
+	}
+	fn, err := in.eval(x[0], e)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, 0, len(x)-1)
+	for _, arg := range x[1:] {
+		v, err := in.eval(arg, e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return in.call(fn, args)
+}
+
+func (in *interp) call(fn interface{}, args []interface{}) (interface{}, error) {
+	if in.depth++; in.depth > maxDepth {
+		return nil, errorf("maximum call depth exceeded")
+	}
+	defer func() { in.depth-- }()
+	switch fn := fn.(type) {
+	case builtin:
+		return fn(in, args)
+	case *lambda:
+		if len(args) != len(fn.params) {
+			return nil, errorf("function expects %v arguments got %v", len(fn.params), len(args))
+		}
+		local := &env{vars: make(map[symbol]interface{}, len(args)), parent: fn.env}
+		for i, p := range fn.params {
+			local.vars[p] = args[i]
+		}
+		return in.evalBody(fn.body, local)
+	}
+	return nil, errorf("%v is not a function", typeName(fn))
+}
+
+// charge n steps for work done by builtins proportional to the size of their output
+func (in *interp) charge(n int) error {
+	if in == nil {
+		return nil
+	}
+	if in.steps += n; in.steps > in.maxSteps {
+		return ErrStepLimit
+	}
+	return nil
+}
+
+func (in *interp) evalIf(x sexpr, e *env) (interface{}, error) {
+	if len(x) < 3 || len(x) > 4 {
+		return nil, errorf("if requires a condition, a value and an optional else value")
+	}
+	cond, err := in.eval(x[1], e)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return in.eval(x[2], e)
+	} else if len(x) == 4 {
+		return in.eval(x[3], e)
+	}
+	return nil, nil
+}
+
+func (in *interp) evalDefine(x sexpr, e *env) (interface{}, error) {
+	if len(x) < 3 {
+		return nil, errorf("define requires a name and a value")
+	}
+	switch target := x[1].(type) {
+	case symbol:
+		if len(x) != 3 {
+			return nil, errorf("define %v requires a single value", target)
+		}
+		v, err := in.eval(x[2], e)
+		if err != nil {
+			return nil, err
+		}
+		e.vars[target] = v
+		return v, nil
+	case sexpr:
+		// (define (name params...) body...)
+		if len(target) == 0 {
+			return nil, errorf("define requires a function name")
+		}
+		name, ok := target[0].(symbol)
+		if !ok {
+			return nil, errorf("invalid function name")
+		}
+		fn, err := newLambda(target[1:], x[2:], e)
+		if err != nil {
+			return nil, err
+		}
+		e.vars[name] = fn
+		return fn, nil
+	}
+	return nil, errorf("invalid define")
+}
+
+func (in *interp) evalLet(x sexpr, e *env) (interface{}, error) {
+	if len(x) < 3 {
+		return nil, errorf("let requires a list of bindings and a body")
+	}
+	bindings, ok := x[1].(sexpr)
+	if !ok {
+		return nil, errorf("let requires a list of bindings and a body")
+	}
+	local := &env{vars: make(map[symbol]interface{}, len(bindings)), parent: e}
+	for _, b := range bindings {
+		pair, ok := b.(sexpr)
+		if !ok || len(pair) != 2 {
+			return nil, errorf("let bindings must be (name value) pairs")
+		}
+		name, ok := pair[0].(symbol)
+		if !ok {
+			return nil, errorf("invalid let binding")
+		}
+		v, err := in.eval(pair[1], local)
+		if err != nil {
+			return nil, err
+		}
+		local.vars[name] = v
+	}
+	return in.evalBody(x[2:], local)
+}
+
+func (in *interp) evalBody(body []interface{}, e *env) (interface{}, error) {
+	var v interface{}
+	for _, expr := range body {
+		var err error
+		if v, err = in.eval(expr, e); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func newLambda(params interface{}, body []interface{}, e *env) (*lambda, error) {
+	list, ok := params.(sexpr)
+	if !ok {
+		return nil, errorf("function parameters must be a list")
+	}
+	fn := &lambda{body: body, env: e}
+	for _, p := range list {
+		name, ok := p.(symbol)
+		if !ok {
+			return nil, errorf("function parameters must be names")
+		}
+		fn.params = append(fn.params, name)
+	}
+	return fn, nil
+}
+
+func (e *env) lookup(name symbol) (interface{}, error) {
+	for ; e != nil; e = e.parent {
+		if v, ok := e.vars[name]; ok {
+			return v, nil
+		}
+	}
+	return nil, errorf("undefined %v", name)
+}
+
+func tokenize(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, errorf("unterminated string")
+			}
+			tokens = append(tokens, src[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(src) && !unicode.IsSpace(rune(src[j])) && !strings.ContainsRune(`();"`, rune(src[j])) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func read(tokens []string) (interface{}, []string, error) {
+	tok := tokens[0]
+	tokens = tokens[1:]
+	switch tok {
+	case "(":
+		list := sexpr{}
+		for {
+			if len(tokens) == 0 {
+				return nil, nil, errorf("missing )")
+			}
+			if tokens[0] == ")" {
+				return list, tokens[1:], nil
+			}
+			var item interface{}
+			var err error
+			if item, tokens, err = read(tokens); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+	case ")":
+		return nil, nil, errorf("unexpected )")
+	case "nil":
+		return nil, tokens, nil
+	case "true":
+		return true, tokens, nil
+	case "false":
+		return false, tokens, nil
+	}
+	if tok[0] == '"' {
+		str, err := strconv.Unquote(tok)
+		if err != nil {
+			return nil, nil, errorf("invalid string %v", tok)
+		}
+		return str, tokens, nil
+	}
+	if isNumber(tok) {
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, nil, errorf("invalid number %v", tok)
+		}
+		return n, tokens, nil
+	}
+	return symbol(tok), tokens, nil
+}
+
+// isNumber returns true if tok starts like a number, names like inf or NaN are symbols
+func isNumber(tok string) bool {
+	tok = strings.TrimLeft(tok, "+-")
+	return tok != "" && (unicode.IsDigit(rune(tok[0])) || (tok[0] == '.' && len(tok) > 1 && unicode.IsDigit(rune(tok[1]))))
+}
+
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+// isData returns false if v contains functions
+func isData(v interface{}) bool {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if !isData(item) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if !isData(item) {
+				return false
+			}
+		}
+	case *lambda, builtin:
+		return false
+	}
+	return true
+}
+
+func errorf(msg string, args ...interface{}) error {
+	return Error{Msg: fmt.Sprintf(msg, args...)}
+}
+
+func (e Error) Error() string {
+	return "script: " + e.Msg
+}
+
+func (s strErr) Error() string {
+	return string(s)
+}
@@ -0,0 +1,75 @@
+package script
+
+import (
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, c := range []struct {
+		src      string
+		expected interface{}
+	}{
+		{`(+ 1 2 3)`, 6.0},
+		{`(define (fact n) (if (<= n 1) 1 (* n (fact (- n 1))))) (fact 5)`, 120.0},
+		{`(let ((a 1) (b (+ a 1))) (list a b))`, []interface{}{1.0, 2.0}},
+		{`(get (put (dict "a" 1) "b" 2) "b")`, 2.0},
+		{`(keys (merge (dict "b" 1) (dict "a" 2)))`, []interface{}{"a", "b"}},
+		{`(union (list 1 2) (list 2 3))`, []interface{}{1.0, 2.0, 3.0}},
+		{`(reduce + 0 (map (fn (x) (* x x)) (filter (fn (x) (> x 1)) (list 1 2 3))))`, 13.0},
+		{`(and 1 nil 2)`, nil},
+		{`(or nil false "x")`, "x"},
+		{`(str "a" 1 true) ; comment`, "a1true"},
+		{`(get (list 1 2) 5 "default")`, "default"},
+		{`(= (dict "a" (list 1)) (dict "a" (list 1)))`, true},
+		{`(max ours theirs)`, 2.0},
+	} {
+		p, err := Parse(c.src)
+		if err != nil {
+			t.Fatalf("%v: %v", c.src, err)
+		}
+		out, err := p.Run(map[string]interface{}{"ours": 1.0, "theirs": 2.0}, 0)
+		if err != nil {
+			t.Fatalf("%v: %v", c.src, err)
+		}
+		if !equal(out, c.expected) {
+			t.Fatalf("%v: expecting %v got %v", c.src, c.expected, out)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, src := range []string{
+		`(define (loop) (loop)) (loop)`,
+		`(define (grow l n) (if (= n 0) l (grow (concat l l) (- n 1)))) (grow (list 1) 64)`,
+	} {
+		p, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Run(nil, 10000); err == nil {
+			t.Fatalf("%v should be stopped", src)
+		}
+	}
+
+	p, err := Parse(`(fail "cannot merge " key)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Run(map[string]interface{}{"key": "k"}, 0)
+	if e, ok := err.(Error); !ok || !e.Fail || e.Msg != "cannot merge k" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	for _, src := range []string{`(+ 1`, `)`, `"open`, `(fn x 1)`} {
+		if p, err := Parse(src); err == nil {
+			if _, err := p.Run(nil, 0); err == nil {
+				t.Fatalf("%v should fail", src)
+			}
+		}
+	}
+	if p, err := Parse(`(fn (x) x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := p.Run(nil, 0); err == nil {
+		t.Fatal("Functions should not be returned")
+	}
+}
@@ -0,0 +1,80 @@
+package isodb
+
+type (
+	// Snapshot is an immutable view of a commit.
+	//
+	// Files are read through the object cache of the Repo (see SetCacheSize), so
+	// repeated reads do not decode the same objects again. A Snapshot can be shared
+	// between goroutines.
+	Snapshot struct {
+		repo   *Repo
+		commit BlobRef
+		root   BlobRef
+	}
+)
+
+// Snapshot returns a Snapshot of the given commit, an empty ref returns an empty Snapshot
+func (r *Repo) Snapshot(commit BlobRef) (*Snapshot, error) {
+	root, err := r.commitRoot(commit)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		repo:   r,
+		commit: commit,
+		root:   root,
+	}, nil
+}
+
+// Commit returns the ref of the commit of this snapshot
+func (s *Snapshot) Commit() BlobRef {
+	return s.commit
+}
+
+// Get returns the content of the document
+func (s *Snapshot) Get(k DocumentKey) (Blob, error) {
+	ref, err := s.ContentRef(k)
+	if err != nil {
+		return Blob{}, err
+	}
+	return s.repo.GetBlob(ref)
+}
+
+// ContentRef returns the ref of the content of the document
+func (s *Snapshot) ContentRef(k DocumentKey) (BlobRef, error) {
+	return findContent(s, s.root, k)
+}
+
+// Has returns true if the document exists
+func (s *Snapshot) Has(k DocumentKey) (bool, error) {
+	_, err := s.ContentRef(k)
+	if err == ErrDocumentNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the keys of all documents in set, sorted by K
+func (s *Snapshot) List(set string) ([]DocumentKey, error) {
+	var keys []DocumentKey
+	err := listSet(s, s.root, set, func(k DocumentKey) error {
+		keys = append(keys, k)
+		return nil
+	})
+	return keys, err
+}
+
+// Diff returns the documents which changed from this snapshot to other
+func (s *Snapshot) Diff(other *Snapshot) ([]DocumentChange, error) {
+	var changes []DocumentChange
+	err := diffFiles(s.repo, s.root, other.root, nil, func(c DocumentChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	return changes, err
+}
+
+// loadFile implements fileLoader
+func (s *Snapshot) loadFile(ref BlobRef) (File, error) {
+	return s.repo.loadFile(ref)
+}
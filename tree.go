@@ -0,0 +1,122 @@
+package isodb
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// treeEdit is a change to the leaf at the end of path, removals can also
+	// target a folder to remove everything below it
+	treeEdit struct {
+		path    []string
+		content BlobRef
+		remove  bool
+	}
+
+	// treeBuilder applies a batch of edits to a tree, every File touched by the edits is
+	// rebuilt exactly once, from the leafs up to the root.
+	treeBuilder struct {
+		files fileLoader
+		blobs blobMap
+	}
+)
+
+// build applies edits to the tree starting at root and returns the ref of the new root.
+//
+// The root is kept even if it ends up without children
+func (tb *treeBuilder) build(root BlobRef, edits []treeEdit) (BlobRef, error) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return lessPath(edits[i].path, edits[j].path)
+	})
+	file, err := tb.files.loadFile(root)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	updated, err := tb.children(file, edits, 0)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	return tb.blobs.put(updated), nil
+}
+
+// folder rebuilds the File at ref (which might be empty) named name, returns an empty ref
+// if the resulting File does not have any children
+func (tb *treeBuilder) folder(ref BlobRef, name string, edits []treeEdit, depth int) (BlobRef, error) {
+	if len(edits[0].path) == depth {
+		n := 1
+		for n < len(edits) && len(edits[n].path) == depth {
+			n++
+		}
+		// if the same path was edited more than once the last edit wins
+		e := edits[n-1]
+		switch {
+		case n < len(edits) && e.remove:
+			// the folder is removed and rebuilt with the remaining edits
+			ref, edits = BlobRef{}, edits[n:]
+		case n < len(edits):
+			return BlobRef{}, errors.Errorf("isodb: %v is a leaf and a folder", name)
+		case e.remove:
+			return BlobRef{}, nil
+		default:
+			leaf := &File{Name: name, Leaf: true}
+			return tb.blobs.put(leaf.SetFileContent(e.content)), nil
+		}
+	}
+	file, err := tb.files.loadFile(ref)
+	if err != nil {
+		return BlobRef{}, err
+	}
+	if ref.IsZero() {
+		file.Name = name
+	}
+	updated, err := tb.children(file, edits, depth)
+	if err != nil {
+		return BlobRef{}, err
+	} else if len(updated.Children) == 0 {
+		return BlobRef{}, nil
+	}
+	return tb.blobs.put(updated), nil
+}
+
+// children rebuilds the children of file affected by edits, edits must be sorted by path
+func (tb *treeBuilder) children(file File, edits []treeEdit, depth int) (*File, error) {
+	children := make(map[string]BlobRef, len(file.Children))
+	for _, e := range file.Children {
+		children[e.Name] = e.Ref
+	}
+	for len(edits) > 0 {
+		name := edits[0].path[depth]
+		n := 1
+		for n < len(edits) && edits[n].path[depth] == name {
+			n++
+		}
+		ref, err := tb.folder(children[name], name, edits[:n], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if ref.IsZero() {
+			delete(children, name)
+		} else {
+			children[name] = ref
+		}
+		edits = edits[n:]
+	}
+	updated := file
+	updated.Children = make(EdgeList, 0, len(children))
+	for name, ref := range children {
+		updated.Children = append(updated.Children, Edge{Name: name, Ref: ref})
+	}
+	updated.Children.SortInPlace()
+	return &updated, nil
+}
+
+func lessPath(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,147 @@
+package isodb
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type (
+	// Tx is a unit of work executed by Repo.Update.
+	//
+	// Reads see the documents written by the Tx itself and then the commit pointed
+	// by the pointer when the Tx started (see ChangesetView).
+	Tx struct {
+		repo *Repo
+		head BlobRef
+		view *ChangesetView
+
+		// keys and sets read by the Tx, used to detect if concurrent changes
+		// affected the Tx
+		reads map[DocumentKey]bool
+		sets  map[string]bool
+	}
+)
+
+const (
+	minUpdateBackoff = time.Millisecond
+	maxUpdateBackoff = 200 * time.Millisecond
+)
+
+// Update executes fn against the commit currently pointed by ptr, commits the
+// changes made by fn and advances ptr. Returns the ref of the new commit.
+//
+// If ptr is moved by someone else before the change is committed and the concurrent
+// changes do not touch any document read or written by fn, the changes are committed
+// on top of the new value. Otherwise fn is executed again against the new value.
+// Attempts are spaced with an exponential backoff until ctx is done.
+//
+// If fn returns an error, nothing is committed and the error is returned.
+func (r *Repo) Update(ctx context.Context, ptr string, fn func(tx *Tx) error) (BlobRef, error) {
+	backoff := minUpdateBackoff
+	var tx *Tx
+	for {
+		head, err := r.GetPointer(ptr)
+		if err != nil && err != ErrPointerNotFound {
+			return BlobRef{}, err
+		}
+		if tx == nil || !tx.rebase(head) {
+			tx = r.newTx(head)
+			if err := fn(tx); err != nil {
+				return BlobRef{}, err
+			}
+		}
+		commit, err := r.Apply(tx.view.Changeset())
+		if err != nil {
+			return BlobRef{}, err
+		}
+		err = r.UpdatePointerWith(PointerUpdate{Name: ptr, Old: tx.head, New: commit, Reason: "update"})
+		if err == nil {
+			return commit, nil
+		} else if err != ErrInvalidOldRef {
+			return BlobRef{}, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return BlobRef{}, ctx.Err()
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))):
+		}
+		if backoff *= 2; backoff > maxUpdateBackoff {
+			backoff = maxUpdateBackoff
+		}
+	}
+}
+
+func (r *Repo) newTx(head BlobRef) *Tx {
+	tx := &Tx{
+		repo:  r,
+		head:  head,
+		reads: make(map[DocumentKey]bool),
+		sets:  make(map[string]bool),
+	}
+	if head.IsZero() {
+		tx.view = r.ChangesetView(NewChangeset())
+	} else {
+		tx.view = r.ChangesetView(NewChangeset(head))
+	}
+	return tx
+}
+
+// Head returns the commit used by this Tx for reads, empty if the pointer did not exist.
+func (tx *Tx) Head() BlobRef {
+	return tx.head
+}
+
+// Get returns the content of the document
+func (tx *Tx) Get(k DocumentKey) (Blob, error) {
+	tx.reads[k] = true
+	return tx.view.Get(k)
+}
+
+// Has returns true if the document exists
+func (tx *Tx) Has(k DocumentKey) (bool, error) {
+	tx.reads[k] = true
+	return tx.view.Has(k)
+}
+
+// List returns the keys of the documents in set
+func (tx *Tx) List(set string) ([]DocumentKey, error) {
+	tx.sets[set] = true
+	return tx.view.List(set)
+}
+
+// Put stages the document to be committed
+func (tx *Tx) Put(k DocumentKey, b Blob) {
+	tx.view.Put(k, b)
+}
+
+// Delete stages the removal of the document
+func (tx *Tx) Delete(k DocumentKey) {
+	tx.view.Delete(k)
+}
+
+// rebase moves the staged changes on top of head if the changes made between
+// tx.head and head do not touch anything read or written by tx.
+//
+// Returns false if the Tx must be executed again.
+func (tx *Tx) rebase(head BlobRef) bool {
+	if tx.head.IsZero() || head.IsZero() {
+		return false
+	}
+	changes, err := tx.repo.Diff(tx.head, head)
+	if err != nil {
+		return false
+	}
+	staged := tx.view.Changeset()
+	for _, c := range changes {
+		_, written := staged.leafs[c.Key]
+		if written || tx.reads[c.Key] || tx.sets[c.Key.Set] || staged.Deleted(c.Key) {
+			return false
+		}
+	}
+	cs := NewChangeset(head)
+	cs.leafs, cs.removed = staged.leafs, staged.removed
+	tx.head, tx.view = head, tx.repo.ChangesetView(cs)
+	return true
+}
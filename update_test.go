@@ -0,0 +1,61 @@
+package isodb
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	repo := newRepo(t)
+	counter := NewRandomKey("counters")
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Update(ctx, "heads/main", func(tx *Tx) error {
+				var value int
+				if b, err := tx.Get(counter); err == nil {
+					value, _ = strconv.Atoi(string(b.Content))
+				} else if err != ErrDocumentNotFound {
+					return err
+				}
+				tx.Put(counter, NewBlobString(strconv.Itoa(value+1)))
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	head, err := repo.GetPointer("heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := repo.GetContentAtKey(head, counter); err != nil {
+		t.Fatal(err)
+	} else if string(b.Content) != "10" {
+		t.Fatalf("Every update should be applied, got %v", string(b.Content))
+	}
+
+	other := NewRandomKey("counters")
+	_, err = repo.Update(ctx, "heads/main", func(tx *Tx) error {
+		tx.Put(other, NewBlobString("1"))
+		keys, err := tx.List("counters")
+		if err != nil {
+			return err
+		} else if len(keys) != 2 {
+			t.Fatalf("List should include staged documents: %v", keys)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,331 @@
+package isodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andrebq/isodb/script"
+)
+
+type (
+	// Validator is a script (see package script) which checks every document written
+	// to Set, like validate_doc_update in CouchDB.
+	//
+	// Validators are stored as documents in ValidatorsSet, so the rules travel together
+	// with the data. Apply checks the changed documents against the validators of the base
+	// commit and of the commit being created, a document must be accepted by both, so a
+	// changeset cannot relax the rules for the documents it writes. Validators of
+	// ValidatorsSet can protect the rules themselves. The script
+	// is executed with the variables doc and old holding the decoded JSON content of the new
+	// and previous versions (nil if the document is new or removed, a string if it is not
+	// JSON) and key with the document key as Set/K.
+	//
+	// The document is rejected if the script fails (see the fail function) or returns false.
+	// A Set can have many validators, all of them must accept the document.
+	Validator struct {
+		Set    string `json:"set"`
+		Script string `json:"script"`
+	}
+
+	// ValidationError describes why a document was rejected
+	ValidationError struct {
+		Key    DocumentKey
+		Reason string
+	}
+
+	// ErrValidation is returned when some documents are not valid
+	ErrValidation struct {
+		Errors []ValidationError
+	}
+
+	// validators holds the rules for each set
+	validators struct {
+		scripts map[string][]*script.Program
+		schemas map[string]*jsonSchema
+	}
+)
+
+const (
+	// ValidatorsSet contains the Validator definitions of the repository
+	ValidatorsSet = "_validators"
+
+	// MaxValidatorSteps limits the number of steps of a single validator execution
+	MaxValidatorSteps = 1000000
+)
+
+// ToBlob encodes the validator to be stored in ValidatorsSet
+func (v Validator) ToBlob() Blob {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		panic("this should never ever happen! " + err.Error())
+	}
+	return Blob{Content: blob}
+}
+
+// ValidateCommit checks every document in commit against the validators and schemas
+// (see Schema) of the same commit.
+//
+// Documents are validated as if they were new (old is nil), so it can be used to
+// re-validate imported commits. Returns ErrValidation listing the invalid documents.
+func (r *Repo) ValidateCommit(commit BlobRef) error {
+	root, err := r.commitRoot(commit)
+	if err != nil {
+		return err
+	}
+	vs, err := r.loadValidators(root, nil)
+	if err != nil {
+		return err
+	}
+	var report ErrValidation
+	for _, set := range vs.sets() {
+		err := walkSet(r, root, set, func(k DocumentKey, content BlobRef) error {
+			b, err := r.GetBlob(content)
+			if err != nil {
+				return err
+			}
+			if reason := vs.check(k, &b, nil); reason != "" {
+				report.Errors = append(report.Errors, ValidationError{Key: k, Reason: reason})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return report.orNil()
+}
+
+// validateChanges checks the documents changed by cs against the validators of the
+// tree at root and the validators of the tree after cs is applied to it, documents
+// must be accepted by both
+func (r *Repo) validateChanges(root BlobRef, cs *Changeset) error {
+	before, err := r.loadValidators(root, nil)
+	if err != nil {
+		return err
+	}
+	after := before
+	if cs.changesSet(ValidatorsSet) || cs.changesSet(SchemasSet) {
+		if after, err = r.loadValidators(root, cs); err != nil {
+			return err
+		}
+	}
+	if len(before.sets()) == 0 && len(after.sets()) == 0 {
+		return nil
+	}
+	var report ErrValidation
+	check := func(k DocumentKey, doc *Blob) error {
+		if !before.has(k.Set) && !after.has(k.Set) {
+			return nil
+		}
+		var old *Blob
+		content, err := findContent(r, root, k)
+		switch {
+		case err == nil:
+			b, err := r.GetBlob(content)
+			if err != nil {
+				return err
+			}
+			old = &b
+		case err != ErrDocumentNotFound:
+			return err
+		case doc == nil:
+			// removing a document which does not exist is a no-op
+			return nil
+		}
+		reason := before.check(k, doc, old)
+		if reason == "" {
+			reason = after.check(k, doc, old)
+		}
+		if reason != "" {
+			report.Errors = append(report.Errors, ValidationError{Key: k, Reason: reason})
+		}
+		return nil
+	}
+	for k, b := range cs.leafs {
+		b := b
+		if err := check(k, &b); err != nil {
+			return err
+		}
+	}
+	for k := range cs.removed {
+		if err := check(k, nil); err != nil {
+			return err
+		}
+	}
+	return report.orNil()
+}
+
+// loadValidators parses the validators and schemas in the tree at root after cs is applied to it
+func (r *Repo) loadValidators(root BlobRef, cs *Changeset) (validators, error) {
+	vs := validators{scripts: make(map[string][]*script.Program), schemas: make(map[string]*jsonSchema)}
+	if err := r.loadSchemas(root, cs, vs.schemas); err != nil {
+		return vs, err
+	}
+	docs, err := r.setDocs(root, ValidatorsSet, cs)
+	if err != nil {
+		return vs, err
+	}
+	for _, k := range sortedKeys(docs) {
+		var v Validator
+		if err := json.Unmarshal(docs[k].Content, &v); err != nil {
+			return vs, invalidDefinition(k, err)
+		}
+		if v.Set == "" {
+			return vs, ErrInvalidDefinition{Key: k, Reason: "validator without a set"}
+		}
+		prog, err := script.Parse(v.Script)
+		if err != nil {
+			return vs, invalidDefinition(k, err)
+		}
+		vs.scripts[v.Set] = append(vs.scripts[v.Set], prog)
+	}
+	return vs, nil
+}
+
+// loadSchemas compiles the schemas in the tree at root after cs is applied to it
+func (r *Repo) loadSchemas(root BlobRef, cs *Changeset, schemas map[string]*jsonSchema) error {
+	docs, err := r.setDocs(root, SchemasSet, cs)
+	if err != nil {
+		return err
+	}
+	defined := make(map[string]DocumentKey)
+	for _, k := range sortedKeys(docs) {
+		var s Schema
+		if err := json.Unmarshal(docs[k].Content, &s); err != nil {
+			return invalidDefinition(k, err)
+		}
+		if s.Set == "" {
+			return ErrInvalidDefinition{Key: k, Reason: "schema without a set"}
+		}
+		if other, dup := defined[s.Set]; dup {
+			return ErrInvalidDefinition{Key: k, Reason: fmt.Sprintf("set %q already has the schema %v", s.Set, other)}
+		}
+		compiled, err := compileSchema(s.Schema)
+		if err != nil {
+			return invalidDefinition(k, err)
+		}
+		defined[s.Set], schemas[s.Set] = k, compiled
+	}
+	return nil
+}
+
+// sets returns the sorted list of sets with validators or schemas
+func (vs validators) sets() []string {
+	var sets []string
+	for set := range vs.scripts {
+		sets = append(sets, set)
+	}
+	for set := range vs.schemas {
+		if _, ok := vs.scripts[set]; !ok {
+			sets = append(sets, set)
+		}
+	}
+	sort.Strings(sets)
+	return sets
+}
+
+func (vs validators) has(set string) bool {
+	_, hasScripts := vs.scripts[set]
+	_, hasSchema := vs.schemas[set]
+	return hasScripts || hasSchema
+}
+
+// check runs the schema and the validators of the set of k, doc and old are nil if the
+// document does not exist. Returns the reason if the document is rejected
+func (vs validators) check(k DocumentKey, doc, old *Blob) string {
+	if schema, ok := vs.schemas[k.Set]; ok && doc != nil {
+		v, err := decodeJSON(*doc)
+		if err != nil {
+			return "document is not JSON"
+		}
+		if errs := schema.validate(v, "#", 0); len(errs) > 0 {
+			return strings.Join(errs, "; ")
+		}
+	}
+	vars := map[string]interface{}{
+		"key": k.String(),
+		"doc": scriptValue(doc),
+		"old": scriptValue(old),
+	}
+	for _, prog := range vs.scripts[k.Set] {
+		result, err := prog.Run(vars, MaxValidatorSteps)
+		if err != nil {
+			return err.Error()
+		} else if result == false {
+			return "rejected by validator"
+		}
+	}
+	return ""
+}
+
+// setDocs returns the documents of set in the tree at root after cs (which might be nil)
+// is applied to it
+func (r *Repo) setDocs(root BlobRef, set string, cs *Changeset) (map[DocumentKey]Blob, error) {
+	docs := make(map[DocumentKey]Blob)
+	err := walkSet(r, root, set, func(k DocumentKey, content BlobRef) error {
+		if cs != nil {
+			if _, changed := cs.leafs[k]; changed || cs.Deleted(k) {
+				return nil
+			}
+		}
+		b, err := r.GetBlob(content)
+		if err != nil {
+			return err
+		}
+		docs[k] = b
+		return nil
+	})
+	if err != nil || cs == nil {
+		return docs, err
+	}
+	for k, b := range cs.leafs {
+		if k.Set == set {
+			docs[k] = b
+		}
+	}
+	return docs, nil
+}
+
+// scriptValue returns the content as a script value, nil if b is nil and a string
+// if the content is not JSON
+func scriptValue(b *Blob) interface{} {
+	if b == nil {
+		return nil
+	}
+	if v, err := decodeJSON(*b); err == nil {
+		return v
+	}
+	return string(b.Content)
+}
+
+func sortedKeys(docs map[DocumentKey]Blob) []DocumentKey {
+	keys := make([]DocumentKey, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// orNil returns nil if there are no errors, errors are sorted by key
+func (e ErrValidation) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	sort.SliceStable(e.Errors, func(i, j int) bool {
+		return e.Errors[i].Key.String() < e.Errors[j].Key.String()
+	})
+	return e
+}
+
+func (e ErrValidation) Error() string {
+	invalid := make([]string, 0, len(e.Errors))
+	for _, v := range e.Errors {
+		invalid = append(invalid, fmt.Sprintf("%v (%v)", v.Key, v.Reason))
+	}
+	return "isodb: invalid documents: " + strings.Join(invalid, ", ")
+}
@@ -0,0 +1,131 @@
+package isodb
+
+import (
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	repo := newRepo(t)
+	validator := NewRandomKey(ValidatorsSet)
+	cs := NewChangeset()
+	cs.Put(validator, Validator{Set: "people", Script: `
+		(if (= doc nil)
+			(if (get old "locked") (fail "locked") true)
+			(and (= (type (get doc "name")) "string")
+			     (or (= old nil) (= (get old "name") (get doc "name")))))`}.ToBlob())
+	bob, alice := NewRandomKey("people"), NewRandomKey("people")
+	cs.Put(bob, NewBlobString(`{"name": "bob"}`))
+	cs.Put(alice, NewBlobString(`{"name": "alice", "locked": true}`))
+	cs.Put(NewRandomKey("other"), NewBlobString(`not validated`))
+	commit, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := NewRandomKey("people")
+	cs = NewChangeset(commit)
+	cs.Put(bob, NewBlobString(`{"name": "robert"}`))
+	cs.Put(invalid, NewBlobString(`{"name": 1}`))
+	cs.Delete(alice)
+	cs.Delete(NewRandomKey("people"))
+	_, err = repo.Apply(cs)
+	report, ok := err.(ErrValidation)
+	if !ok {
+		t.Fatalf("Apply should fail with ErrValidation got %v", err)
+	}
+	expected := map[DocumentKey]string{
+		bob:     "rejected by validator",
+		invalid: "rejected by validator",
+		alice:   "script: locked",
+	}
+	if len(report.Errors) != len(expected) {
+		t.Fatalf("Unexpected report %v", report)
+	}
+	for _, e := range report.Errors {
+		if expected[e.Key] != e.Reason {
+			t.Fatalf("%v should be rejected with %q got %q", e.Key, expected[e.Key], e.Reason)
+		}
+	}
+
+	// the validators of the base commit still apply to the changeset removing them
+	cs.Delete(validator)
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Removing a validator should not disable it for the same changeset")
+	}
+	unchecked := NewChangeset(commit)
+	unchecked.Delete(validator)
+	if commit, err = repo.Apply(unchecked); err != nil {
+		t.Fatal(err)
+	}
+	cs = NewChangeset(commit)
+	cs.Put(bob, NewBlobString(`{"name": "robert"}`))
+	cs.Put(invalid, NewBlobString(`{"name": 1}`))
+	cs.Delete(alice)
+	relaxed, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ValidateCommit(relaxed); err != nil {
+		t.Fatal(err)
+	}
+
+	// adding a validator only checks the documents changed with it
+	cs = NewChangeset(relaxed)
+	cs.Put(validator, Validator{Set: "people", Script: `(= (type (get doc "name")) "string")`}.ToBlob())
+	strict, err := repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ValidateCommit(strict)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 1 || report.Errors[0].Key != invalid {
+		t.Fatalf("ValidateCommit should report the existing invalid document got %v", err)
+	}
+	cs = NewChangeset(strict)
+	cs.Put(invalid, NewBlobString(`{"name": "fixed"}`))
+	if strict, err = repo.Apply(cs); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ValidateCommit(strict); err != nil {
+		t.Fatal(err)
+	}
+
+	// documents written together with a new validator must also be accepted by it
+	cs = NewChangeset(strict)
+	cs.Put(NewRandomKey(ValidatorsSet), Validator{Set: "people", Script: `(if (get doc "age") true false)`}.ToBlob())
+	cs.Put(NewRandomKey("people"), NewBlobString(`{"name": "carol"}`))
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Documents should be checked by the validators added by the changeset")
+	}
+
+	closed := NewRandomKey(ValidatorsSet)
+	cs = NewChangeset(strict)
+	cs.Put(closed, Validator{Set: "people", Script: `(fail "closed")`}.ToBlob())
+	// only the validators changed, so no document is checked
+	commit, err = repo.Apply(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ValidateCommit(commit)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 2 {
+		t.Fatalf("ValidateCommit should report every document of the set got %v", err)
+	}
+
+	cs = NewChangeset(commit)
+	cs.Put(NewRandomKey(ValidatorsSet), NewBlobString(`{"set": "people", "script": "(unbalanced"}`))
+	if _, err := repo.Apply(cs); err == nil {
+		t.Fatal("Apply should fail with an invalid validator")
+	}
+
+	// validators of ValidatorsSet protect the rules
+	cs = NewChangeset(commit)
+	cs.Put(NewRandomKey(ValidatorsSet), Validator{Set: ValidatorsSet, Script: `(if (= old nil) true (fail "frozen"))`}.ToBlob())
+	if commit, err = repo.Apply(cs); err != nil {
+		t.Fatal(err)
+	}
+	cs = NewChangeset(commit)
+	cs.Delete(closed)
+	_, err = repo.Apply(cs)
+	if report, ok := err.(ErrValidation); !ok || len(report.Errors) != 1 || report.Errors[0].Reason != "script: frozen" {
+		t.Fatalf("Removing a protected validator should fail got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package isodb
+
+import (
+	"sort"
+
+	"github.com/segmentio/ksuid"
+)
+
+type (
+	// ChangesetView reads documents from a Changeset falling back to its parent commit,
+	// so a unit of work can read its own writes.
+	//
+	// Like Changeset, it shouldn't be shared between different goroutines
+	ChangesetView struct {
+		repo   *Repo
+		cs     *Changeset
+		parent *Snapshot
+	}
+)
+
+// ChangesetView returns a view which reads the documents staged in cs and then
+// the documents in the parent commit of cs.
+func (r *Repo) ChangesetView(cs *Changeset) *ChangesetView {
+	cs.ensureLeafs()
+	return &ChangesetView{repo: r, cs: cs}
+}
+
+// Changeset returns the Changeset used by this view
+func (v *ChangesetView) Changeset() *Changeset {
+	return v.cs
+}
+
+// Get returns the content of the document, staged changes take precedence over the parent commit
+func (v *ChangesetView) Get(k DocumentKey) (Blob, error) {
+	if v.cs.Deleted(k) {
+		return Blob{}, ErrDocumentNotFound
+	}
+	if b, ok := v.cs.Read(nil, k); ok {
+		return b, nil
+	}
+	parent, err := v.snapshot()
+	if err != nil {
+		return Blob{}, err
+	}
+	return parent.Get(k)
+}
+
+// Has returns true if the document exists in the view
+func (v *ChangesetView) Has(k DocumentKey) (bool, error) {
+	_, err := v.Get(k)
+	if err == ErrDocumentNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the keys of the documents in set, including staged documents and
+// excluding the ones staged for removal.
+func (v *ChangesetView) List(set string) ([]DocumentKey, error) {
+	parent, err := v.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parent.List(set)
+	if err != nil {
+		return nil, err
+	}
+	merged := keys[:0]
+	for _, k := range keys {
+		if _, staged := v.cs.leafs[k]; !staged && !v.cs.Deleted(k) {
+			merged = append(merged, k)
+		}
+	}
+	for k := range v.cs.leafs {
+		if k.Set == set {
+			merged = append(merged, k)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return ksuid.Compare(merged[i].K, merged[j].K) < 0
+	})
+	return merged, nil
+}
+
+// Put stages the document in the Changeset
+func (v *ChangesetView) Put(k DocumentKey, b Blob) {
+	v.cs.Put(k, b)
+}
+
+// Delete stages the removal of the document in the Changeset
+func (v *ChangesetView) Delete(k DocumentKey) {
+	v.cs.Delete(k)
+}
+
+// snapshot returns the Snapshot of the parent commit
+func (v *ChangesetView) snapshot() (*Snapshot, error) {
+	if v.parent != nil && v.parent.Commit() == v.cs.base() {
+		return v.parent, nil
+	}
+	parent, err := v.repo.Snapshot(v.cs.base())
+	if err != nil {
+		return nil, err
+	}
+	v.parent = parent
+	return parent, nil
+}
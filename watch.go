@@ -0,0 +1,64 @@
+package isodb
+
+import (
+	"context"
+	"strings"
+)
+
+// Watch returns a channel which receives an event for every change made to the pointers
+// starting with prefix after Watch returns, until ctx is done.
+//
+// If the KV implements WatchKV (like the badger implementation), changes made by other
+// handles to the same store are delivered too.
+//
+// Events are dropped if the receiver falls behind, use GetPointer or PointerLog
+// to get the current state.
+func (r *Repo) Watch(ctx context.Context, prefix string) <-chan PointerEvent {
+	return r.subscribe(ctx, prefix).ch
+}
+
+// subscribe returns a subscription to the pointers starting with prefix, its channel is
+// closed after ctx is done
+func (r *Repo) subscribe(ctx context.Context, prefix string) *pointerSub {
+	sub := newPointerSub(prefix)
+	wkv, watchable := r.kv.(WatchKV)
+	if !watchable {
+		r.hub.subscribe(sub)
+		go func() {
+			<-ctx.Done()
+			r.hub.unsubscribe(sub)
+			close(sub.ch)
+		}()
+		return sub
+	}
+	active, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(sub.ch)
+		defer close(done)
+		wkv.Watch(ctx, reflogPrefix+prefix, func() { close(active) }, func(k string, b Blob) {
+			if ev, ok := pointerEventFromLog(k, b); ok {
+				sub.send(ev)
+			}
+		})
+	}()
+	// wait until changes made after subscribe returns are delivered
+	select {
+	case <-active:
+	case <-done:
+	}
+	return sub
+}
+
+// pointerEventFromLog decodes the event from a pointer log entry, returns false
+// if k is not a log entry
+func pointerEventFromLog(k string, b Blob) (PointerEvent, bool) {
+	sep := strings.LastIndex(k, ":")
+	if !strings.HasPrefix(k, reflogPrefix) || sep < 0 {
+		return PointerEvent{}, false
+	}
+	var e PointerLogEntry
+	if err := defaultCodec.decode(&e, b); err != nil {
+		return PointerEvent{}, false
+	}
+	return PointerEvent{Name: k[len(reflogPrefix):sep], Old: e.Old, New: e.New}, true
+}
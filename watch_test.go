@@ -0,0 +1,52 @@
+package isodb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	repo := newRepo(t)
+	other := NewRepoWithKV(repo.kv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	commit, err := repo.Apply(NewChangeset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := repo.Watch(ctx, HeadsNamespace)
+	next := func() PointerEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for event")
+		}
+		return PointerEvent{}
+	}
+
+	// changes made right after Watch returns are delivered, from any handle
+	if err := other.UpdatePointer(TagPointer("v1"), commit, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.UpdatePointer(HeadPointer("other"), commit, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev.Name != HeadPointer("other") || !ev.Old.IsZero() || ev.New != commit {
+		t.Fatalf("Watch should receive updates made by other handles: %v", ev)
+	}
+	if err := repo.UpdatePointer(HeadPointer("main"), commit, BlobRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev.Name != HeadPointer("main") || !ev.Old.IsZero() || ev.New != commit {
+		t.Fatalf("Unexpected event: %v", ev)
+	}
+
+	cancel()
+	for ev := range events {
+		t.Fatalf("Unexpected event: %v", ev)
+	}
+}